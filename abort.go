@@ -0,0 +1,158 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// Telnet commands sent before ABOR, as described in RFC 959 section 4.1.3
+const (
+	telnetIAC = 255 // Interpret As Command
+	telnetIP  = 244 // Interrupt Process
+	telnetDM  = 242 // Data Mark
+)
+
+// Abort issues an ABOR FTP command to abort the previous FTP command and any
+// associated transfer. If a Response returned by Retr is still open, it is
+// aborted as with Response.Abort.
+//
+// The control connection stays usable afterwards.
+func (c *ServerConn) Abort() error {
+	if c.response != nil {
+		return c.response.Abort()
+	}
+
+	return c.abort(false)
+}
+
+// abort sends the Telnet "Interrupt Process" and "Synch" signals followed by
+// the ABOR command, then reads the replies. When aborting a transfer, its
+// reply is read first: 426 or 451 if it was interrupted, 226 if it completed
+// before ABOR was received.
+func (c *ServerConn) abort(transfer bool) error {
+	if c.broken != nil {
+		return c.broken
+	}
+
+	// The transfer was interrupted by a context, give the server some time
+	// to reply so that the control connection can be recovered.
+	interrupted := c.watcher.interrupted()
+	if interrupted {
+		if err := c.netConn.SetDeadline(time.Now().Add(c.recoverTimeout())); err != nil {
+			return err
+		}
+	}
+
+	// The Telnet signals can not be inserted in a TLS stream
+	if c.options.tlsConfig == nil {
+		if err := c.sendTelnetSynch(); err != nil {
+			return err
+		}
+	}
+
+	code, msg, err := c.cmd(-1, "ABOR")
+	if err != nil {
+		return err
+	}
+
+	// The reply of the aborted command is followed by the reply to ABOR. The
+	// reply of a transfer always comes first, even if it completed.
+	if transfer || code == StatusTransfertAborted || code == StatusActionAborted {
+		code, msg, err = c.readResponse("ABOR", -1)
+		if err != nil {
+			return err
+		}
+	}
+
+	if code != StatusClosingDataConnection && code != StatusDataConnectionOpen {
+		return &Error{Command: "ABOR", Code: code, Msg: msg}
+	}
+
+	if interrupted {
+		c.watcher.setRecovered()
+	}
+
+	return nil
+}
+
+// sendTelnetSynch sends the Telnet "Interrupt Process" signal followed by the
+// "Synch" signal: the Data Mark is sent as TCP urgent data, so that the
+// server processes the ABOR command even if it is busy with the transfer.
+func (c *ServerConn) sendTelnetSynch() error {
+	if _, err := c.netConn.Write([]byte{telnetIAC, telnetIP, telnetIAC}); err != nil {
+		return err
+	}
+
+	return sendUrgent(c.netConn, []byte{telnetDM})
+}
+
+// Abort aborts the transfer with an ABOR FTP command, without waiting for
+// the remaining data. If the whole data has already been read, Abort is
+// equivalent to Close.
+//
+// After the first call, Abort and Close will do nothing and return nil.
+func (r *Response) Abort() error {
+	if r.closed {
+		return nil
+	}
+	if r.eof {
+		return r.Close()
+	}
+
+	var errs []error
+
+	if err := r.conn.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := r.c.abort(true); err != nil {
+		errs = append(errs, err)
+	} else if w := r.c.watcher; w.interrupted() {
+		errs = append(errs, w.ctx.Err())
+	}
+
+	return r.finish(errors.Join(errs...))
+}
+
+// abort aborts an upload: the data connection is reset instead of being
+// closed, since the server would take the end of the data for the end of the
+// file, and the transfer is aborted with an ABOR FTP command.
+func (w *writer) abort() error {
+	var errs []error
+
+	if err := resetConn(w.conn); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := w.c.abort(true); err != nil {
+		errs = append(errs, err)
+	} else if watcher := w.c.watcher; watcher.interrupted() {
+		errs = append(errs, watcher.ctx.Err())
+	}
+
+	return errors.Join(errs...)
+}
+
+// resetConn closes a data connection with a TCP reset, discarding the data
+// not sent yet. The wrappers of the connection are bypassed, so that no TLS
+// close_notify alert is sent either.
+func resetConn(conn net.Conn) error {
+	for {
+		switch wrapper := conn.(type) {
+		case *tls.Conn:
+			conn = wrapper.NetConn()
+			continue
+		case *loggedConn:
+			conn = wrapper.Conn
+			continue
+		case *limitedConn:
+			conn = wrapper.Conn
+			continue
+		case *net.TCPConn:
+			_ = wrapper.SetLinger(0)
+		}
+		return conn.Close()
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package ftp
+
+import "net"
+
+// sendUrgent writes b. Urgent data is not supported on this platform, so it
+// is sent as regular data.
+func sendUrgent(conn net.Conn, b []byte) error {
+	_, err := conn.Write(b)
+	return err
+}
@@ -0,0 +1,153 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bigTestData is larger than the socket buffers, so that its transfer can
+// not complete before being aborted
+var bigTestData = bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+
+func TestResponseAbort(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/big", bigTestData)
+	c := dialTestServer(t, server)
+
+	r, err := c.Retr("/big")
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, bigTestData[:1024], buf)
+
+	assert.NoError(t, r.Abort())
+	assert.NoError(t, r.Abort(), "abort twice")
+	assert.NoError(t, r.Close(), "close after abort")
+
+	// The control connection is still usable
+	size, err := c.FileSize("/big")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(bigTestData)), size)
+}
+
+func TestResponseAbortAfterEOF(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	c := dialTestServer(t, server)
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+
+	buf, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, testData, string(buf))
+
+	// The transfer is complete, ABOR is not sent
+	assert.NoError(t, r.Abort())
+	assert.NoError(t, c.NoOp())
+}
+
+func TestResponseAbortAfterTransfer(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	server.handle("RETR", func(sess *testSession, arg string) {
+		sess.retr(arg)
+
+		// Complete the transfer before reading ABOR
+		<-sess.transfer.done
+	})
+	c := dialTestServer(t, server)
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+	_, err = r.Read(make([]byte, 1))
+	require.NoError(t, err)
+
+	// The reply of the transfer and the reply to ABOR are both read
+	assert.NoError(t, r.Abort())
+
+	dir, err := c.CurrentDir()
+	assert.NoError(t, err)
+	assert.Equal(t, "/", dir)
+}
+
+func TestServerConnAbort(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/big", bigTestData)
+	c := dialTestServer(t, server)
+
+	// No transfer in progress
+	assert.NoError(t, c.Abort())
+
+	r, err := c.Retr("/big")
+	require.NoError(t, err)
+
+	assert.NoError(t, c.Abort())
+	assert.Nil(t, c.response)
+
+	_, err = r.Read(make([]byte, 1))
+	assert.Error(t, err, "read after abort")
+	assert.NoError(t, r.Close())
+	assert.NoError(t, c.NoOp())
+}
+
+func TestContextTransferAborted(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/big", bigTestData)
+	c := dialTestServer(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := c.RetrContext(ctx, "/big")
+	require.NoError(t, err)
+
+	_, err = io.ReadFull(r, make([]byte, 1024))
+	require.NoError(t, err)
+
+	cancel()
+	// Wait for the deadline to be moved to the past
+	time.Sleep(10 * time.Millisecond)
+	_, err = io.ReadAll(r)
+	assert.Error(t, err)
+
+	// The transfer is aborted instead of breaking the connection
+	assert.ErrorIs(t, r.Close(), context.Canceled)
+	assert.NoError(t, c.NoOp())
+}
+
+// stalledReader returns data once done is closed
+type stalledReader struct {
+	done <-chan struct{}
+}
+
+func (r stalledReader) Read(buf []byte) (int, error) {
+	<-r.done
+	// Wait for the deadline to be moved to the past
+	time.Sleep(10 * time.Millisecond)
+	return copy(buf, "yyyyyyyy"), nil
+}
+
+func TestContextUploadAborted(t *testing.T) {
+	server := newTestServer(t)
+	c := dialTestServer(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	r := io.MultiReader(strings.NewReader("xxxxxxxx"), stalledReader{ctx.Done()})
+	err := c.StorContext(ctx, "/f", r)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The partial file is not stored as if it was complete
+	assert.Nil(t, server.lookup("/f"))
+	assert.Contains(t, server.receivedLines(), "ABOR")
+	assert.NoError(t, c.NoOp())
+}
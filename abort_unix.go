@@ -0,0 +1,33 @@
+//go:build unix
+
+package ftp
+
+import (
+	"net"
+	"syscall"
+)
+
+// sendUrgent writes b as TCP urgent data
+func sendUrgent(conn net.Conn, b []byte) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		_, err := conn.Write(b)
+		return err
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errSend error
+	err = raw.Write(func(fd uintptr) bool {
+		errSend = syscall.Sendto(int(fd), b, syscall.MSG_OOB, nil)
+		return errSend != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+
+	return errSend
+}
@@ -0,0 +1,130 @@
+package ftp
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receivedCommands returns the given commands received by the server, in
+// order, without their arguments
+func receivedCommands(server *testServer, names ...string) []string {
+	var commands []string
+	for _, line := range server.receivedLines() {
+		command, _, _ := strings.Cut(line, " ")
+		for _, name := range names {
+			if command == name {
+				commands = append(commands, command)
+			}
+		}
+	}
+	return commands
+}
+
+func TestActiveMode(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	c := dialTestServer(t, server, DialWithActiveMode(true))
+
+	assert.NoError(c.Stor("/file", strings.NewReader(testData)))
+	assert.Equal(testData, string(server.file("/file")))
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+	buf, err := io.ReadAll(r)
+	assert.NoError(err)
+	assert.Equal(testData, string(buf))
+	assert.NoError(r.Close())
+
+	entries, err := c.List("/")
+	assert.NoError(err)
+	assert.Len(entries, 1)
+
+	assert.Equal([]string{"EPRT", "STOR", "EPRT", "RETR", "EPRT", "MLSD"},
+		receivedCommands(server, "EPRT", "PORT", "EPSV", "PASV", "STOR", "RETR", "MLSD"))
+}
+
+func TestActiveModePortRange(t *testing.T) {
+	// Find a free port to build the range from
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, l.Close())
+
+	server := newTestServer(t)
+	c := dialTestServer(t, server, DialWithActiveMode(true), DialWithActivePortRange(port, port))
+
+	_, err = c.List("/")
+	assert.NoError(t, err)
+	assert.Contains(t, server.receivedLines(), "EPRT |1|127.0.0.1|"+strconv.Itoa(port)+"|")
+}
+
+func TestActiveModePORTFallback(t *testing.T) {
+	server := newTestServer(t)
+	server.handle("EPRT", func(sess *testSession, arg string) {
+		sess.reply("500 EPRT not understood")
+	})
+	c := dialTestServer(t, server, DialWithActiveMode(true))
+
+	_, err := c.List("/")
+	assert.NoError(t, err)
+	_, err = c.List("/")
+	assert.NoError(t, err)
+
+	// EPRT is not tried again once rejected
+	assert.Equal(t, []string{"EPRT", "PORT", "MLSD", "PORT", "MLSD"},
+		receivedCommands(server, "EPRT", "PORT", "MLSD"))
+}
+
+func TestActiveModeIPv6(t *testing.T) {
+	server := newTestServerAt(t, "::1")
+	c := dialTestServer(t, server, DialWithActiveMode(true))
+
+	_, err := c.List("/")
+	assert.NoError(t, err)
+
+	var announced []string
+	for _, line := range server.receivedLines() {
+		if strings.HasPrefix(line, "EPRT ") {
+			announced = append(announced, line)
+		}
+	}
+	if assert.Len(t, announced, 1) {
+		assert.True(t, strings.HasPrefix(announced[0], "EPRT |2|::1|"), announced[0])
+	}
+}
+
+func TestActiveModeForeignPeer(t *testing.T) {
+	// Another loopback address plays a third party
+	foreign := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	probe, err := foreign.Dial("tcp", "127.0.0.1:1")
+	if err != nil && !strings.Contains(err.Error(), "refused") {
+		t.Skipf("can not dial from 127.0.0.2: %s", err)
+	}
+	if probe != nil {
+		_ = probe.Close()
+	}
+
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	server.handle("RETR", func(sess *testSession, arg string) {
+		if conn, err := foreign.Dial("tcp", sess.dataAddr); err == nil {
+			_, _ = conn.Write([]byte("injected"))
+			_ = conn.Close()
+		}
+		sess.builtin("RETR", arg)
+	})
+	c := dialTestServer(t, server, DialWithActiveMode(true))
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+	buf, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, testData, string(buf))
+	assert.NoError(t, r.Close())
+}
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -278,6 +280,35 @@ func TestMissingFolderDeleteDirRecur(t *testing.T) {
 	mock.Wait()
 }
 
+func TestExplicitTLS(t *testing.T) {
+	for _, active := range []bool{false, true} {
+		t.Run(fmt.Sprintf("active=%t", active), func(t *testing.T) {
+			assert := assert.New(t)
+			server := newTestServer(t)
+			tlsConfig := server.enableTLS()
+			c := dialTestServer(t, server, DialWithExplicitTLS(tlsConfig), DialWithActiveMode(active))
+
+			assert.NoError(c.Stor("/file", strings.NewReader(testData)))
+			assert.Equal(testData, string(server.file("/file")))
+
+			r, err := c.Retr("/file")
+			require.NoError(t, err)
+			buf, err := io.ReadAll(r)
+			assert.NoError(err)
+			assert.Equal(testData, string(buf))
+			assert.NoError(r.Close())
+
+			entries, err := c.List("/")
+			assert.NoError(err)
+			assert.Len(entries, 1)
+
+			lines := server.receivedLines()
+			assert.Contains(lines, "AUTH TLS")
+			assert.Contains(lines, "PROT P")
+		})
+	}
+}
+
 func TestListCurrentDir(t *testing.T) {
 	mock, c := openConnExt(t, "127.0.0.1", "no-time", DialWithDisabledMLSD(true))
 
@@ -417,3 +448,62 @@ func TestDialWithDialer(t *testing.T) {
 
 	assert.Equal(t, true, dialerCalled)
 }
+
+func TestStorWriter(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	c := dialTestServer(t, server)
+
+	w, err := c.StorWriter("/file")
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(w, "%s", testData)
+	assert.NoError(err)
+	assert.NoError(w.Close())
+	assert.NoError(w.Close())
+	assert.Equal(testData, string(server.file("/file")))
+
+	_, err = w.Write([]byte("closed"))
+	assert.ErrorIs(err, net.ErrClosed)
+
+	w, err = c.AppendWriter("/file")
+	require.NoError(t, err)
+	_, err = io.Copy(w, strings.NewReader(" appended"))
+	assert.NoError(err)
+	assert.NoError(w.Close())
+	assert.Equal(testData+" appended", string(server.file("/file")))
+
+	// Empty file
+	w, err = c.StorWriter("/empty")
+	require.NoError(t, err)
+	assert.NoError(w.Close())
+	assert.Equal([]byte{}, server.file("/empty"))
+
+	_, err = c.StorWriter("/missing/file")
+	assert.Error(err)
+
+	// The connection is usable after the uploads
+	assert.NoError(c.NoOp())
+}
+
+func TestStorWriterRejected(t *testing.T) {
+	server := newTestServer(t)
+	server.handle("STOR", func(sess *testSession, arg string) {
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+		sess.reply("150 Ok to send data")
+		_, _ = io.Copy(io.Discard, conn)
+		_ = conn.Close()
+		sess.reply("552 Quota exceeded")
+	})
+	c := dialTestServer(t, server)
+
+	w, err := c.StorWriter("/file")
+	require.NoError(t, err)
+	_, err = io.WriteString(w, testData)
+	assert.NoError(t, err)
+	assert.ErrorContains(t, w.Close(), "Quota exceeded")
+	assert.NoError(t, c.NoOp())
+}
@@ -0,0 +1,419 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrConnBroken is returned by the operations of a ServerConn whose control
+// connection was left in an unknown state, for example when an operation was
+// interrupted by its context. Such a ServerConn must be closed with Quit.
+var ErrConnBroken = errors.New("ftp: connection is broken")
+
+// DefaultRecoverTimeout is the time given to the server to reply when
+// aborting a transfer interrupted by a context. DialWithShutTimeout
+// overrides it.
+const DefaultRecoverTimeout = 5 * time.Second
+
+// aLongTimeAgo is a deadline in the past, used to interrupt blocked I/O
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ctxWatcher interrupts the I/O of a ServerConn when a context is done, by
+// moving the deadlines of its control and data connections to the past.
+type ctxWatcher struct {
+	c    *ServerConn
+	ctx  context.Context
+	stop chan struct{}
+	done chan struct{}
+
+	mu        sync.Mutex
+	control   net.Conn
+	data      net.Conn
+	fired     bool
+	recovered bool // the control connection is usable despite the interruption
+	finished  bool
+}
+
+// watch starts watching ctx for the operation about to be executed.
+// The data connections opened until finish is called are watched too.
+func (c *ServerConn) watch(ctx context.Context) *ctxWatcher {
+	w := &ctxWatcher{
+		c:    c,
+		ctx:  ctx,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+
+		control: c.netConn,
+	}
+	c.watcher = w
+
+	go func() {
+		defer close(w.done)
+
+		select {
+		case <-ctx.Done():
+			w.mu.Lock()
+			w.fired = true
+			_ = w.control.SetDeadline(aLongTimeAgo)
+			if w.data != nil {
+				_ = w.data.SetDeadline(aLongTimeAgo)
+			}
+			w.mu.Unlock()
+		case <-w.stop:
+		}
+	}()
+
+	return w
+}
+
+// setControlConn replaces the watched control connection after a reconnection
+func (w *ctxWatcher) setControlConn(conn net.Conn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.control = conn
+	if w.fired {
+		_ = conn.SetDeadline(aLongTimeAgo)
+	}
+}
+
+// setDataConn adds a data connection to the watched connections
+func (w *ctxWatcher) setDataConn(conn net.Conn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.data = conn
+	if w.fired {
+		_ = conn.SetDeadline(aLongTimeAgo)
+	}
+}
+
+// interrupted reports whether the context interrupted the operation.
+// It is safe to call on a nil watcher.
+func (w *ctxWatcher) interrupted() bool {
+	if w == nil {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fired
+}
+
+// contextDone returns the channel closed when the watched context is done.
+// It is safe to call on a nil watcher, whose channel is never closed.
+func (w *ctxWatcher) contextDone() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.ctx.Done()
+}
+
+// setRecovered records that the control connection was brought back in a
+// known state after an interruption.
+func (w *ctxWatcher) setRecovered() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.recovered = true
+}
+
+// recoverTimeout returns the time given to the server to reply when
+// recovering the control connection after an interruption.
+func (c *ServerConn) recoverTimeout() time.Duration {
+	if c.options.shutTimeout != 0 {
+		return c.options.shutTimeout
+	}
+	return DefaultRecoverTimeout
+}
+
+// finish stops watching the context and returns the result of the operation.
+//
+// If the context interrupted the operation, the context error is returned
+// instead of err, and the ServerConn is marked as broken unless the control
+// connection could be recovered.
+func (w *ctxWatcher) finish(err error) error {
+	if w.finished {
+		return err
+	}
+	w.finished = true
+
+	close(w.stop)
+	<-w.done
+	w.c.watcher = nil
+
+	if !w.fired {
+		return err
+	}
+
+	if err == nil || w.recovered {
+		if errDeadline := w.c.netConn.SetDeadline(time.Time{}); errDeadline != nil {
+			return errDeadline
+		}
+	}
+
+	if err == nil {
+		// The operation completed before being interrupted
+		return nil
+	}
+	if w.recovered {
+		return w.ctx.Err()
+	}
+
+	w.c.broken = fmt.Errorf("%w: %w", ErrConnBroken, w.ctx.Err())
+	return w.ctx.Err()
+}
+
+// withContext executes f, interrupting it when ctx is done.
+func (c *ServerConn) withContext(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.watch(ctx).finish(f())
+}
+
+// LoginContext is like Login but the operation is interrupted when ctx is done.
+func (c *ServerConn) LoginContext(ctx context.Context, user, password string) error {
+	return c.withContext(ctx, func() error {
+		return c.Login(user, password)
+	})
+}
+
+// TypeContext is like Type but the operation is interrupted when ctx is done.
+func (c *ServerConn) TypeContext(ctx context.Context, transferType TransferType) error {
+	return c.withContext(ctx, func() error {
+		return c.Type(transferType)
+	})
+}
+
+// NameListContext is like NameList but the operation is interrupted when ctx is done.
+func (c *ServerConn) NameListContext(ctx context.Context, path string) (entries []string, err error) {
+	err = c.withContext(ctx, func() error {
+		entries, err = c.NameList(path)
+		return err
+	})
+	return entries, err
+}
+
+// ListContext is like List but the operation is interrupted when ctx is done.
+func (c *ServerConn) ListContext(ctx context.Context, path string) (entries []*Entry, err error) {
+	err = c.withContext(ctx, func() error {
+		entries, err = c.List(path)
+		return err
+	})
+	return entries, err
+}
+
+// GetEntryContext is like GetEntry but the operation is interrupted when ctx is done.
+func (c *ServerConn) GetEntryContext(ctx context.Context, path string) (entry *Entry, err error) {
+	err = c.withContext(ctx, func() error {
+		entry, err = c.GetEntry(path)
+		return err
+	})
+	return entry, err
+}
+
+// ChangeDirContext is like ChangeDir but the operation is interrupted when ctx is done.
+func (c *ServerConn) ChangeDirContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.ChangeDir(path)
+	})
+}
+
+// ChangeDirToParentContext is like ChangeDirToParent but the operation is
+// interrupted when ctx is done.
+func (c *ServerConn) ChangeDirToParentContext(ctx context.Context) error {
+	return c.withContext(ctx, c.ChangeDirToParent)
+}
+
+// CurrentDirContext is like CurrentDir but the operation is interrupted when ctx is done.
+func (c *ServerConn) CurrentDirContext(ctx context.Context) (dir string, err error) {
+	err = c.withContext(ctx, func() error {
+		dir, err = c.CurrentDir()
+		return err
+	})
+	return dir, err
+}
+
+// FileSizeContext is like FileSize but the operation is interrupted when ctx is done.
+func (c *ServerConn) FileSizeContext(ctx context.Context, path string) (size int64, err error) {
+	err = c.withContext(ctx, func() error {
+		size, err = c.FileSize(path)
+		return err
+	})
+	return size, err
+}
+
+// GetTimeContext is like GetTime but the operation is interrupted when ctx is done.
+func (c *ServerConn) GetTimeContext(ctx context.Context, path string) (t time.Time, err error) {
+	err = c.withContext(ctx, func() error {
+		t, err = c.GetTime(path)
+		return err
+	})
+	return t, err
+}
+
+// HashContext is like Hash but the operation is interrupted when ctx is done.
+func (c *ServerConn) HashContext(ctx context.Context, path string, algo HashAlgorithm) (sum []byte, err error) {
+	err = c.withContext(ctx, func() error {
+		sum, err = c.Hash(path, algo)
+		return err
+	})
+	return sum, err
+}
+
+// SetTimeContext is like SetTime but the operation is interrupted when ctx is done.
+func (c *ServerConn) SetTimeContext(ctx context.Context, path string, t time.Time) error {
+	return c.withContext(ctx, func() error {
+		return c.SetTime(path, t)
+	})
+}
+
+// RetrContext is like Retr but the transfer is interrupted when ctx is done.
+//
+// The context is watched until the returned Response is closed.
+func (c *ServerConn) RetrContext(ctx context.Context, path string) (*Response, error) {
+	return c.RetrFromContext(ctx, path, 0)
+}
+
+// RetrFromContext is like RetrFrom but the transfer is interrupted when ctx
+// is done.
+//
+// The context is watched until the returned Response is closed.
+func (c *ServerConn) RetrFromContext(ctx context.Context, path string, offset uint64) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	w := c.watch(ctx)
+	r, err := c.RetrFrom(path, offset)
+	if err != nil {
+		return nil, w.finish(err)
+	}
+
+	r.watcher = w
+	return r, nil
+}
+
+// StorContext is like Stor but the transfer is interrupted when ctx is done.
+func (c *ServerConn) StorContext(ctx context.Context, path string, r io.Reader) error {
+	return c.StorFromContext(ctx, path, r, 0)
+}
+
+// StorFromContext is like StorFrom but the transfer is interrupted when ctx
+// is done.
+func (c *ServerConn) StorFromContext(ctx context.Context, path string, r io.Reader, offset uint64) error {
+	return c.withContext(ctx, func() error {
+		return c.StorFrom(path, r, offset)
+	})
+}
+
+// StorWriterContext is like StorWriter but the transfer is interrupted when
+// ctx is done.
+//
+// The context is watched until the returned WriteCloser is closed.
+func (c *ServerConn) StorWriterContext(ctx context.Context, path string) (io.WriteCloser, error) {
+	return c.writerContext(ctx, func() (io.WriteCloser, error) {
+		return c.StorWriter(path)
+	})
+}
+
+// AppendWriterContext is like AppendWriter but the transfer is interrupted
+// when ctx is done.
+//
+// The context is watched until the returned WriteCloser is closed.
+func (c *ServerConn) AppendWriterContext(ctx context.Context, path string) (io.WriteCloser, error) {
+	return c.writerContext(ctx, func() (io.WriteCloser, error) {
+		return c.AppendWriter(path)
+	})
+}
+
+// writerContext opens a writer with open, watching ctx until it is closed
+func (c *ServerConn) writerContext(ctx context.Context, open func() (io.WriteCloser, error)) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	watcher := c.watch(ctx)
+	w, err := open()
+	if err != nil {
+		return nil, watcher.finish(err)
+	}
+
+	w.(*writer).watcher = watcher
+	return w, nil
+}
+
+// AppendContext is like Append but the transfer is interrupted when ctx is done.
+func (c *ServerConn) AppendContext(ctx context.Context, path string, r io.Reader) error {
+	return c.withContext(ctx, func() error {
+		return c.Append(path, r)
+	})
+}
+
+// DownloadFileContext is like DownloadFile but the transfer is interrupted
+// when ctx is done.
+func (c *ServerConn) DownloadFileContext(ctx context.Context, remote, local string, options ...TransferOption) error {
+	return c.withContext(ctx, func() error {
+		return c.DownloadFile(remote, local, options...)
+	})
+}
+
+// UploadFileContext is like UploadFile but the transfer is interrupted when
+// ctx is done.
+func (c *ServerConn) UploadFileContext(ctx context.Context, local, remote string, options ...TransferOption) error {
+	return c.withContext(ctx, func() error {
+		return c.UploadFile(local, remote, options...)
+	})
+}
+
+// RenameContext is like Rename but the operation is interrupted when ctx is done.
+func (c *ServerConn) RenameContext(ctx context.Context, from, to string) error {
+	return c.withContext(ctx, func() error {
+		return c.Rename(from, to)
+	})
+}
+
+// DeleteContext is like Delete but the operation is interrupted when ctx is done.
+func (c *ServerConn) DeleteContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.Delete(path)
+	})
+}
+
+// RemoveDirRecurContext is like RemoveDirRecur but the operation is
+// interrupted when ctx is done.
+func (c *ServerConn) RemoveDirRecurContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.RemoveDirRecur(path)
+	})
+}
+
+// MakeDirContext is like MakeDir but the operation is interrupted when ctx is done.
+func (c *ServerConn) MakeDirContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.MakeDir(path)
+	})
+}
+
+// RemoveDirContext is like RemoveDir but the operation is interrupted when ctx is done.
+func (c *ServerConn) RemoveDirContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.RemoveDir(path)
+	})
+}
+
+// NoOpContext is like NoOp but the operation is interrupted when ctx is done.
+func (c *ServerConn) NoOpContext(ctx context.Context) error {
+	return c.withContext(ctx, c.NoOp)
+}
+
+// LogoutContext is like Logout but the operation is interrupted when ctx is done.
+func (c *ServerConn) LogoutContext(ctx context.Context) error {
+	return c.withContext(ctx, c.Logout)
+}
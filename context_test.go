@@ -0,0 +1,134 @@
+package ftp
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialTestServer returns a client logged in to a testServer
+func dialTestServer(t *testing.T, server *testServer, options ...DialOption) *ServerConn {
+	c, err := Dial(server.Addr(), options...)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Login("anonymous", "anonymous"))
+	t.Cleanup(func() {
+		_ = c.Quit()
+	})
+
+	return c
+}
+
+// stallUntilCleanup returns a channel closed at the end of the test, before
+// the testServer is closed.
+func stallUntilCleanup(t *testing.T) chan struct{} {
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		close(release)
+	})
+	return release
+}
+
+func TestContextSuccess(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/dir/file", []byte(testData))
+	c := dialTestServer(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entries, err := c.ListContext(ctx, "/dir")
+	if assert.NoError(err) && assert.Len(entries, 1) {
+		assert.Equal("file", entries[0].Name)
+	}
+
+	r, err := c.RetrContext(ctx, "/dir/file")
+	if assert.NoError(err) {
+		buf, err := io.ReadAll(r)
+		assert.NoError(err)
+		assert.Equal(testData, string(buf))
+		assert.NoError(r.Close())
+	}
+
+	size, err := c.FileSizeContext(ctx, "/dir/file")
+	assert.NoError(err)
+	assert.Equal(int64(len(testData)), size)
+
+	// The connection is still usable once the context is done
+	cancel()
+	assert.NoError(c.NoOp())
+}
+
+func TestContextCancelledBeforeStart(t *testing.T) {
+	server := newTestServer(t)
+	c := dialTestServer(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := c.NoOpContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Nothing was sent, so the connection is still usable
+	assert.NoError(t, c.NoOp())
+}
+
+func TestContextCommandTimeout(t *testing.T) {
+	server := newTestServer(t)
+	release := stallUntilCleanup(t)
+	server.handle("NOOP", func(sess *testSession, arg string) {
+		<-release
+	})
+	c := dialTestServer(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.NoOpContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The reply may still arrive, so the connection can not be used anymore
+	err = c.MakeDir("/dir")
+	assert.ErrorIs(t, err, ErrConnBroken)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestContextTransferCancelled(t *testing.T) {
+	server := newTestServer(t)
+	release := stallUntilCleanup(t)
+	server.handle("RETR", func(sess *testSession, arg string) {
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+		defer conn.Close()
+
+		sess.reply("150 Opening data connection")
+		_, _ = conn.Write([]byte(testData))
+		<-release
+	})
+	// The server does not reply to ABOR, do not wait too long
+	c := dialTestServer(t, server, DialWithShutTimeout(50*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := c.RetrContext(ctx, "/file")
+	require.NoError(t, err)
+
+	buf := make([]byte, len(testData))
+	_, err = io.ReadFull(r, buf)
+	assert.NoError(t, err)
+
+	cancel()
+	_, err = r.Read(buf)
+	assert.Error(t, err)
+
+	err = r.Close()
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, c.NoOp(), ErrConnBroken)
+}
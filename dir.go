@@ -0,0 +1,585 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnsafePath is returned when a name of a listing of the server would make
+// a download write outside of the local directory, or through a symbolic link
+// of the local directory.
+var ErrUnsafePath = errors.New("ftp: unsafe path")
+
+// SymlinkPolicy tells how the directory transfers handle the symbolic links
+type SymlinkPolicy int
+
+// Symbolic link policies
+const (
+	// SymlinkSkip ignores the symbolic links. It is the default.
+	SymlinkSkip SymlinkPolicy = iota
+
+	// SymlinkFollow transfers the files the symbolic links point to as
+	// regular files. The links to directories are skipped, to prevent
+	// loops.
+	SymlinkFollow
+
+	// SymlinkCreate recreates the symbolic links, with the same target. It
+	// is only supported by DownloadDir, since FTP has no command creating
+	// a symbolic link.
+	SymlinkCreate
+)
+
+// TransferWithInclude returns a TransferOption that restricts the files
+// transferred by DownloadDir and UploadDir to the ones matching one of the
+// patterns.
+//
+// The patterns have the syntax of path.Match. A pattern containing a slash
+// matches the path relative to the transferred directory, such as
+// "docs/*.txt", otherwise it matches the base name of the file.
+func TransferWithInclude(patterns ...string) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.include = append(to.include, patterns...)
+	}}
+}
+
+// TransferWithExclude returns a TransferOption that excludes the files and
+// directories matching one of the patterns from the transfers of DownloadDir
+// and UploadDir. The patterns are matched as with TransferWithInclude.
+func TransferWithExclude(patterns ...string) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.exclude = append(to.exclude, patterns...)
+	}}
+}
+
+// TransferWithSymlinks returns a TransferOption that sets how DownloadDir and
+// UploadDir handle the symbolic links.
+func TransferWithSymlinks(policy SymlinkPolicy) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.symlinks = policy
+	}}
+}
+
+// TransferWithConcurrency returns a TransferOption that sets the number of
+// files, and thus of connections, transferred concurrently by
+// Pool.DownloadDir and Pool.UploadDir.
+func TransferWithConcurrency(n int) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.concurrency = n
+	}}
+}
+
+// TransferWithDryRun returns a TransferOption that makes UploadDir report the
+// directories it would create and the files it would upload to f, with the
+// information of the local file, without changing anything on the server.
+func TransferWithDryRun(f func(remote string, info fs.FileInfo)) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.dryRun = f
+	}}
+}
+
+// matchPattern reports whether the relative path matches one of the patterns
+func matchPattern(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether the file or directory is excluded from the
+// transfer
+func (to *transferOptions) excluded(rel string, dir bool) bool {
+	if matchPattern(to.exclude, rel) {
+		return true
+	}
+	return !dir && len(to.include) > 0 && !matchPattern(to.include, rel)
+}
+
+// treeFile is a file of a directory tree to be transferred
+type treeFile struct {
+	remote string
+	local  string
+	mtime  time.Time // zero if unknown
+}
+
+// DownloadDir downloads the remote directory tree into the local directory.
+//
+// The directories are created as needed, and the files are downloaded with
+// DownloadFile, which receives the options. Their modification times are
+// preserved, taken from the listing when the server supports MLSD and from
+// GetTime otherwise. A file is skipped when the local copy has the same size
+// and modification time.
+//
+// The files to download can be selected with TransferWithInclude and
+// TransferWithExclude, and the symbolic links are handled as set with
+// TransferWithSymlinks. ErrUnsafePath is returned if a name of the listings
+// would make the download write outside of the local directory, or through a
+// symbolic link of the local tree.
+func (c *ServerConn) DownloadDir(remote, local string, options ...TransferOption) error {
+	to := newTransferOptions(options)
+
+	files, err := c.downloadTree(remote, local, to)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := c.downloadTreeFile(f, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DownloadDir downloads the remote directory tree into the local directory,
+// as ServerConn.DownloadDir does, downloading several files concurrently.
+//
+// The number of files downloaded concurrently is the maximum number of
+// connections of the pool, unless set with TransferWithConcurrency.
+func (p *Pool) DownloadDir(ctx context.Context, remote, local string, options ...TransferOption) error {
+	to := newTransferOptions(options)
+
+	var files []treeFile
+	err := p.Do(ctx, func(c *ServerConn) (err error) {
+		files, err = c.downloadTree(remote, local, to)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	n := to.concurrency
+	if n <= 0 {
+		n = p.options.maxConns
+	}
+
+	return forEachTreeFile(ctx, files, n, func(ctx context.Context, f treeFile) error {
+		return p.Do(ctx, func(c *ServerConn) error {
+			return c.downloadTreeFile(f, options)
+		})
+	})
+}
+
+// downloadTree walks the remote directory, creating the local directories and
+// symbolic links, and returns the files to download.
+func (c *ServerConn) downloadTree(remote, local string, to *transferOptions) ([]treeFile, error) {
+	if err := os.MkdirAll(local, 0o755); err != nil {
+		return nil, err
+	}
+
+	// The listing only has precise modification times with MLSD
+	preciseTimes := c.mlstSupported && !c.options.forceListHidden
+
+	var files []treeFile
+	w := c.Walk(remote)
+	for w.Next() {
+		e := w.Stat()
+		rel := relPath(remote, w.Path())
+		if !isLocalName(e.Name) || !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsafePath, w.Path())
+		}
+		dest := filepath.Join(local, filepath.FromSlash(rel))
+
+		if to.excluded(rel, e.Type == EntryTypeFolder) {
+			w.SkipDir()
+			continue
+		}
+
+		// An existing link is replaced by the one of the listing
+		checked := rel
+		if e.Type == EntryTypeLink && to.symlinks == SymlinkCreate {
+			checked = path.Dir(rel)
+		}
+		linked, err := hasSymlink(local, checked)
+		if err != nil {
+			return nil, err
+		}
+		if linked {
+			return nil, fmt.Errorf("%w: %s", ErrUnsafePath, w.Path())
+		}
+
+		switch e.Type {
+		case EntryTypeFolder:
+			if err := os.MkdirAll(dest, 0o755); err != nil {
+				return nil, err
+			}
+			continue
+		case EntryTypeLink:
+			switch to.symlinks {
+			case SymlinkCreate:
+				if err := createSymlink(e.Target, dest); err != nil {
+					return nil, err
+				}
+				continue
+			case SymlinkFollow:
+				if c.mlstSupported {
+					target, err := c.GetEntry(w.Path())
+					if err != nil {
+						return nil, err
+					}
+					if target.Type == EntryTypeFolder {
+						continue
+					}
+					e = target
+				}
+			default:
+				continue
+			}
+		}
+
+		mtime := e.Time
+		if !preciseTimes && c.IsGetTimeSupported() {
+			var err error
+			if mtime, err = c.GetTime(w.Path()); err != nil {
+				return nil, err
+			}
+		}
+
+		if unchanged(dest, e, mtime) {
+			continue
+		}
+
+		files = append(files, treeFile{remote: w.Path(), local: dest, mtime: mtime})
+	}
+
+	if err := w.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// downloadTreeFile downloads a file of a directory tree and sets its
+// modification time.
+func (c *ServerConn) downloadTreeFile(f treeFile, options []TransferOption) error {
+	if err := c.DownloadFile(f.remote, f.local, options...); err != nil {
+		return err
+	}
+
+	if f.mtime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(f.local, f.mtime, f.mtime)
+}
+
+// unchanged reports whether the local file has the size of the entry and the
+// given modification time
+func unchanged(local string, e *Entry, mtime time.Time) bool {
+	info, err := os.Stat(local)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if e.Type != EntryTypeLink && uint64(info.Size()) != e.Size {
+		return false
+	}
+	return !mtime.IsZero() && info.ModTime().Equal(mtime)
+}
+
+// createSymlink creates a symbolic link, replacing an existing one
+func createSymlink(target, link string) error {
+	if target == "" {
+		// The listing does not tell where the link points to
+		return nil
+	}
+
+	if current, err := os.Readlink(link); err == nil {
+		if current == target {
+			return nil
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+
+	return os.Symlink(target, link)
+}
+
+// relPath returns the path of a file found by walking root, relative to root
+func relPath(root, p string) string {
+	root = path.Clean(root)
+	switch root {
+	case ".":
+		return p
+	case "/":
+		return strings.TrimPrefix(p, "/")
+	}
+	return strings.TrimPrefix(p, root+"/")
+}
+
+// isLocalName reports whether the name of an entry of a listing designates a
+// file of the listed directory
+func isLocalName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// hasSymlink reports whether the slash-separated path relative to the root
+// directory goes through a symbolic link, so that writing to it would follow
+// the link. The root itself is not checked.
+func hasSymlink(root, rel string) (bool, error) {
+	if rel == "." {
+		return false, nil
+	}
+
+	p := root
+	for _, name := range strings.Split(rel, "/") {
+		p = filepath.Join(p, name)
+		info, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// forEachTreeFile calls f for the files on n goroutines. It stops at the first
+// error, which it returns.
+func forEachTreeFile(ctx context.Context, files []treeFile, n int, f func(ctx context.Context, file treeFile) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan treeFile)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for file := range queue {
+				if errs[i] = f(ctx, file); errs[i] != nil {
+					cancel()
+					return
+				}
+			}
+		}(i)
+	}
+
+feed:
+	for _, file := range files {
+		select {
+		case queue <- file:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := firstError(errs); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// UploadDir uploads the local directory tree into the remote directory.
+//
+// The missing directories are created, including the remote directory and its
+// parents, and the files are uploaded with UploadFile, which receives the
+// options. The modification times are preserved if enabled with
+// TransferWithPreservedModTime.
+//
+// The files to upload can be selected with TransferWithInclude and
+// TransferWithExclude, and the symbolic links are handled as set with
+// TransferWithSymlinks. Nothing is changed on the server in the dry run mode
+// enabled with TransferWithDryRun.
+func (c *ServerConn) UploadDir(local, remote string, options ...TransferOption) error {
+	to := newTransferOptions(options)
+
+	files, err := c.uploadTree(local, remote, to)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := c.UploadFile(f.local, f.remote, options...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UploadDir uploads the local directory tree into the remote directory, as
+// ServerConn.UploadDir does, uploading several files concurrently.
+//
+// The number of files uploaded concurrently is the maximum number of
+// connections of the pool, unless set with TransferWithConcurrency.
+func (p *Pool) UploadDir(ctx context.Context, local, remote string, options ...TransferOption) error {
+	to := newTransferOptions(options)
+
+	var files []treeFile
+	err := p.Do(ctx, func(c *ServerConn) (err error) {
+		files, err = c.uploadTree(local, remote, to)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	n := to.concurrency
+	if n <= 0 {
+		n = p.options.maxConns
+	}
+
+	return forEachTreeFile(ctx, files, n, func(ctx context.Context, f treeFile) error {
+		return p.Do(ctx, func(c *ServerConn) error {
+			return c.UploadFile(f.local, f.remote, options...)
+		})
+	})
+}
+
+// uploadTree walks the local directory, creating the remote directories, and
+// returns the files to upload.
+func (c *ServerConn) uploadTree(local, remote string, to *transferOptions) ([]treeFile, error) {
+	if to.symlinks == SymlinkCreate {
+		return nil, fmt.Errorf("%w: creating symbolic links", ErrNotSupported)
+	}
+
+	var files []treeFile
+	err := filepath.WalkDir(local, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(local, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		dest := path.Join(remote, rel)
+
+		if rel != "." && to.excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if d.Type()&fs.ModeSymlink != 0 {
+			if to.symlinks != SymlinkFollow {
+				return nil
+			}
+			if info, err = os.Stat(name); err == nil && info.IsDir() {
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if to.dryRun != nil {
+			to.dryRun(dest, info)
+			return nil
+		}
+
+		switch {
+		case rel == ".":
+			return c.makeDirAll(dest)
+		case d.IsDir():
+			return c.makeDir(dest)
+		case info.Mode().IsRegular():
+			files = append(files, treeFile{remote: dest, local: name, mtime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// makeDir creates a remote directory, succeeding if it already exists
+func (c *ServerConn) makeDir(dir string) error {
+	existing, err := c.mkdir(dir)
+	if existing != nil && existing.Type == EntryTypeFolder {
+		return nil
+	}
+	return err
+}
+
+// makeDirAll creates a remote directory along with its missing parents
+func (c *ServerConn) makeDirAll(dir string) error {
+	if c.isDir(dir) {
+		return nil
+	}
+
+	if parent := path.Dir(dir); parent != dir {
+		if err := c.makeDirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	return c.makeDir(dir)
+}
+
+// mkdir creates a remote directory. If it fails, the entry of the path is
+// returned along with the error when the path exists.
+func (c *ServerConn) mkdir(dir string) (existing *Entry, err error) {
+	err = c.MakeDir(dir)
+
+	// Most servers reply 550 whatever the reason, some reply 521 when the
+	// directory exists
+	var ftpErr *Error
+	if !errors.As(err, &ftpErr) || (ftpErr.fileReason() == nil && ftpErr.Code != 521) {
+		return nil, err
+	}
+
+	existing, _ = c.stat(dir)
+	return existing, err
+}
+
+// isDir reports whether the remote path is an existing directory
+func (c *ServerConn) isDir(dir string) bool {
+	e, err := c.stat(dir)
+	return err == nil && e.Type == EntryTypeFolder
+}
+
+// stat returns the entry of the remote path, with GetEntry if the server
+// supports MLST, by listing its parent directory otherwise.
+func (c *ServerConn) stat(name string) (*Entry, error) {
+	if c.mlstSupported {
+		return c.GetEntry(name)
+	}
+
+	name = path.Clean(name)
+	base := path.Base(name)
+	if name == "/" || name == "." {
+		// The root directory has no parent to be listed from
+		return &Entry{Name: base, Type: EntryTypeFolder}, nil
+	}
+
+	entries, err := c.List(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Name == base {
+			return e, nil
+		}
+	}
+
+	return nil, fs.ErrNotExist
+}
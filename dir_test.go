@@ -0,0 +1,330 @@
+package ftp
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTree(t *testing.T) *testServer {
+	server := newTestServer(t)
+	server.addFile("/tree/a.txt", []byte("a"))
+	server.addFile("/tree/sub/b.txt", []byte("bb"))
+	server.addFile("/tree/sub/c.log", []byte("ccc"))
+	server.addDir("/tree/empty")
+	return server
+}
+
+// assertLocalFile checks the contents and the modification time of a
+// downloaded file
+func assertLocalFile(t *testing.T, local, data string) {
+	t.Helper()
+
+	got, err := os.ReadFile(local)
+	if assert.NoError(t, err) {
+		assert.Equal(t, data, string(got))
+	}
+
+	info, err := os.Stat(local)
+	if assert.NoError(t, err) {
+		assert.True(t, info.ModTime().Equal(testModTime), "modification time of %s", local)
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	for _, mlst := range []bool{true, false} {
+		server := newTestTree(t)
+		if !mlst {
+			server.handle("FEAT", func(sess *testSession, arg string) {
+				sess.reply("211-Features:\r\n SIZE\r\n MDTM\r\n EPSV\r\n211 End")
+			})
+		}
+		c := dialTestServer(t, server)
+		local := t.TempDir()
+
+		require.NoError(t, c.DownloadDir("/tree", local))
+
+		assertLocalFile(t, filepath.Join(local, "a.txt"), "a")
+		assertLocalFile(t, filepath.Join(local, "sub", "b.txt"), "bb")
+		assertLocalFile(t, filepath.Join(local, "sub", "c.log"), "ccc")
+		assert.DirExists(t, filepath.Join(local, "empty"))
+	}
+}
+
+func TestDownloadDirUnchanged(t *testing.T) {
+	server := newTestTree(t)
+	c := dialTestServer(t, server)
+	local := t.TempDir()
+
+	require.NoError(t, c.DownloadDir("/tree", local))
+
+	retrs := recordCommands(server, "RETR")
+	server.addFile("/tree/sub/b.txt", []byte("changed"))
+
+	require.NoError(t, c.DownloadDir("/tree", local))
+	assert.Equal(t, []string{"RETR"}, *retrs, "only the changed file is downloaded")
+	assertLocalFile(t, filepath.Join(local, "sub", "b.txt"), "changed")
+}
+
+func TestDownloadDirPatterns(t *testing.T) {
+	server := newTestTree(t)
+	c := dialTestServer(t, server)
+	local := t.TempDir()
+
+	err := c.DownloadDir("/tree", local,
+		TransferWithInclude("*.txt", "sub/*.log"),
+		TransferWithExclude("sub/b.txt", "empty"),
+	)
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(local, "a.txt"))
+	assert.NoFileExists(t, filepath.Join(local, "sub", "b.txt"))
+	assert.FileExists(t, filepath.Join(local, "sub", "c.log"))
+	assert.NoDirExists(t, filepath.Join(local, "empty"))
+}
+
+func TestDownloadDirSymlinks(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/tree/a.txt", []byte("a"))
+	// The server resolves the link when it is retrieved
+	server.addFile("/tree/link", []byte("a"))
+	server.handle("FEAT", func(sess *testSession, arg string) {
+		sess.reply("211-Features:\r\n SIZE\r\n MDTM\r\n EPSV\r\n211 End")
+	})
+	server.handle("LIST", func(sess *testSession, arg string) {
+		sess.send([]byte("-rw-r--r-- 1 ftp ftp 1 Dec 13  2020 a.txt\r\n" +
+			"lrwxrwxrwx 1 ftp ftp 5 Dec 13  2020 link -> a.txt\r\n"))
+	})
+	c := dialTestServer(t, server)
+
+	local := t.TempDir()
+	require.NoError(t, c.DownloadDir("/tree", local))
+	assert.NoFileExists(t, filepath.Join(local, "link"))
+
+	local = t.TempDir()
+	require.NoError(t, c.DownloadDir("/tree", local, TransferWithSymlinks(SymlinkCreate)))
+	target, err := os.Readlink(filepath.Join(local, "link"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "a.txt", target)
+	}
+
+	local = t.TempDir()
+	require.NoError(t, c.DownloadDir("/tree", local, TransferWithSymlinks(SymlinkFollow)))
+	assertLocalFile(t, filepath.Join(local, "link"), "a")
+}
+
+func TestDownloadDirUnsafeNames(t *testing.T) {
+	for _, name := range []string{"../escape.txt", "sub/../../escape.txt", "/escape.txt"} {
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.addDir("/tree")
+			server.handle("MLSD", func(sess *testSession, arg string) {
+				sess.send([]byte("type=file;size=1;modify=20201213202400; " + name + "\r\n"))
+			})
+			c := dialTestServer(t, server)
+
+			parent := t.TempDir()
+			local := filepath.Join(parent, "local")
+			err := c.DownloadDir("/tree", local)
+			assert.ErrorIs(t, err, ErrUnsafePath)
+			assert.NoFileExists(t, filepath.Join(parent, "escape.txt"))
+		})
+	}
+}
+
+func TestDownloadDirThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+
+	server := newTestServer(t)
+	server.addFile("/tree/link/passwd", []byte("pwned"))
+	server.handle("FEAT", func(sess *testSession, arg string) {
+		sess.reply("211-Features:\r\n SIZE\r\n EPSV\r\n211 End")
+	})
+	server.handle("LIST", func(sess *testSession, arg string) {
+		switch path.Clean(arg) {
+		case "/tree":
+			// The link is walked first, then the directory of the same name
+			sess.send([]byte("drwxr-xr-x 1 ftp ftp 0 Dec 13  2020 link\r\n" +
+				"lrwxrwxrwx 1 ftp ftp 5 Dec 13  2020 link -> " + outside + "\r\n"))
+		default:
+			sess.list("LIST", arg)
+		}
+	})
+	c := dialTestServer(t, server)
+
+	err := c.DownloadDir("/tree", t.TempDir(), TransferWithSymlinks(SymlinkCreate))
+	assert.ErrorIs(t, err, ErrUnsafePath)
+	assert.NoFileExists(t, filepath.Join(outside, "passwd"))
+}
+
+func TestDownloadDirThroughLocalSymlink(t *testing.T) {
+	outside := t.TempDir()
+
+	server := newTestServer(t)
+	server.addFile("/tree/a/evil.txt", []byte("pwned"))
+	c := dialTestServer(t, server)
+
+	// Left by an earlier download with SymlinkCreate
+	local := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(local, "a")))
+
+	err := c.DownloadDir("/tree", local)
+	assert.ErrorIs(t, err, ErrUnsafePath)
+	assert.NoFileExists(t, filepath.Join(outside, "evil.txt"))
+}
+
+func TestPoolDownloadDir(t *testing.T) {
+	server := newTestTree(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous", PoolWithMaxConns(3))
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	local := t.TempDir()
+
+	require.NoError(t, p.DownloadDir(context.Background(), "/tree", local, TransferWithConcurrency(2)))
+
+	assertLocalFile(t, filepath.Join(local, "a.txt"), "a")
+	assertLocalFile(t, filepath.Join(local, "sub", "b.txt"), "bb")
+	assertLocalFile(t, filepath.Join(local, "sub", "c.log"), "ccc")
+}
+
+func TestPoolDownloadDirError(t *testing.T) {
+	server := newTestTree(t)
+	server.handle("RETR", func(sess *testSession, arg string) {
+		sess.reply("550 Permission denied")
+	})
+	p := NewPool(server.Addr(), "anonymous", "anonymous")
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+
+	err := p.DownloadDir(context.Background(), "/tree", t.TempDir())
+	assert.ErrorIs(t, err, ErrPermission)
+}
+
+// writeLocalTree creates a local directory tree to be uploaded
+func writeLocalTree(t *testing.T) string {
+	t.Helper()
+
+	local := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "sub"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "empty"), 0o755))
+	for name, data := range map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "bb",
+		"sub/c.log": "ccc",
+	} {
+		name = filepath.Join(local, filepath.FromSlash(name))
+		require.NoError(t, os.WriteFile(name, []byte(data), 0o644))
+		require.NoError(t, os.Chtimes(name, testModTime, testModTime))
+	}
+	return local
+}
+
+// assertRemoteDir checks that the remote path is a directory
+func assertRemoteDir(t *testing.T, server *testServer, name string) {
+	t.Helper()
+
+	f := server.lookup(name)
+	if assert.NotNil(t, f, name) {
+		assert.True(t, f.dir, "%s is a directory", name)
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	server := newTestServer(t)
+	server.addDir("/up/deep/sub")
+	c := dialTestServer(t, server)
+
+	require.NoError(t, c.UploadDir(writeLocalTree(t), "/up/deep", TransferWithPreservedModTime(true)))
+
+	assert.Equal(t, "a", string(server.file("/up/deep/a.txt")))
+	assert.Equal(t, "bb", string(server.file("/up/deep/sub/b.txt")))
+	assert.Equal(t, "ccc", string(server.file("/up/deep/sub/c.log")))
+	assertRemoteDir(t, server, "/up/deep/empty")
+	assert.True(t, server.lookup("/up/deep/a.txt").modTime.Equal(testModTime))
+}
+
+func TestUploadDirExisting(t *testing.T) {
+	server := newTestServer(t)
+	server.addDir("/up/sub")
+	server.handle("MKD", func(sess *testSession, arg string) {
+		if server.lookup(sess.resolve(arg)) != nil {
+			sess.reply("521 %s: Directory already exists", arg)
+			return
+		}
+		sess.builtin("MKD", arg)
+	})
+	c := dialTestServer(t, server)
+
+	require.NoError(t, c.UploadDir(writeLocalTree(t), "/up"))
+	assert.Equal(t, "bb", string(server.file("/up/sub/b.txt")))
+
+	server.addFile("/file", nil)
+	err := c.UploadDir(writeLocalTree(t), "/file")
+	var ftpErr *Error
+	if assert.ErrorAs(t, err, &ftpErr, "a file is not an existing directory") {
+		assert.Equal(t, "MKD", ftpErr.Command)
+	}
+}
+
+func TestUploadDirDryRun(t *testing.T) {
+	server := newTestServer(t)
+	c := dialTestServer(t, server)
+	commands := recordCommands(server, "MKD", "STOR")
+
+	var reported []string
+	err := c.UploadDir(writeLocalTree(t), "/up",
+		TransferWithExclude("*.log"),
+		TransferWithDryRun(func(remote string, info fs.FileInfo) {
+			if info.IsDir() {
+				remote += "/"
+			}
+			reported = append(reported, remote)
+		}),
+	)
+	require.NoError(t, err)
+
+	assert.Empty(t, *commands)
+	assert.ElementsMatch(t, []string{"/up/", "/up/a.txt", "/up/empty/", "/up/sub/", "/up/sub/b.txt"}, reported)
+}
+
+func TestUploadDirSymlinks(t *testing.T) {
+	server := newTestServer(t)
+	c := dialTestServer(t, server)
+
+	local := writeLocalTree(t)
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(local, "link")))
+	require.NoError(t, os.Symlink("sub", filepath.Join(local, "dirlink")))
+
+	require.NoError(t, c.UploadDir(local, "/skip"))
+	assert.Nil(t, server.lookup("/skip/link"))
+	assert.Nil(t, server.lookup("/skip/dirlink"))
+
+	require.NoError(t, c.UploadDir(local, "/follow", TransferWithSymlinks(SymlinkFollow)))
+	assert.Equal(t, "a", string(server.file("/follow/link")))
+	assert.Nil(t, server.lookup("/follow/dirlink"))
+
+	err := c.UploadDir(local, "/create", TransferWithSymlinks(SymlinkCreate))
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestPoolUploadDir(t *testing.T) {
+	server := newTestServer(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous", PoolWithMaxConns(3))
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+
+	require.NoError(t, p.UploadDir(context.Background(), writeLocalTree(t), "/up", TransferWithConcurrency(2)))
+
+	assert.Equal(t, "a", string(server.file("/up/a.txt")))
+	assert.Equal(t, "bb", string(server.file("/up/sub/b.txt")))
+	assert.Equal(t, "ccc", string(server.file("/up/sub/c.log")))
+}
@@ -0,0 +1,122 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/textproto"
+	"strings"
+)
+
+// Sentinel errors matched by an *Error with errors.Is, depending on its reply
+// code and message.
+var (
+	// ErrNotFound matches the permanent negative replies meaning that the
+	// file or directory does not exist.
+	ErrNotFound = errors.New("ftp: file not found")
+
+	// ErrPermission matches the negative replies meaning that the operation
+	// is not permitted on the file or directory.
+	ErrPermission = errors.New("ftp: permission denied")
+
+	// ErrNotSupported matches the replies to the commands not implemented by
+	// the server. It is also returned when a feature is not supported.
+	ErrNotSupported = errors.New("ftp: not supported")
+
+	// ErrTransient matches the transient negative replies (4xx), after which
+	// the command may succeed if issued again.
+	ErrTransient = errors.New("ftp: transient error")
+
+	// ErrAuth matches the replies rejecting the credentials or requiring to
+	// log in.
+	ErrAuth = errors.New("ftp: authentication failed")
+)
+
+// Error is a negative reply of the server to a command.
+//
+// It matches the sentinel errors of this package with errors.Is, and unwraps
+// to a *textproto.Error.
+type Error struct {
+	// Command is the command which received the reply, without its
+	// arguments. It is empty for the greeting of the server.
+	Command string
+
+	Code int
+
+	// Msg is the message of the reply. The lines of a multi-line reply are
+	// separated by "\n".
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if e.Command == "" {
+		return fmt.Sprintf("%03d %s", e.Code, e.Msg)
+	}
+	return fmt.Sprintf("%s: %03d %s", e.Command, e.Code, e.Msg)
+}
+
+// Unwrap returns the error as a *textproto.Error
+func (e *Error) Unwrap() error {
+	return &textproto.Error{Code: e.Code, Msg: e.Msg}
+}
+
+// Is reports whether the reply matches target, one of ErrNotFound,
+// ErrPermission, ErrNotSupported, ErrTransient and ErrAuth.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Code >= 500 && e.fileReason() == fs.ErrNotExist
+	case ErrPermission:
+		return e.fileReason() == fs.ErrPermission
+	case ErrNotSupported:
+		return e.Code == StatusBadCommand || e.Code == StatusNotImplemented || e.Code == StatusNotImplementedParameter
+	case ErrTransient:
+		return e.Code >= 400 && e.Code < 500
+	case ErrAuth:
+		return e.Code == StatusNotLoggedIn || e.Code == StatusInvalidCredentials || e.Code == StatusStorNeedAccount
+	}
+	return false
+}
+
+// fileReason returns why a file is unavailable, as fs.ErrNotExist,
+// fs.ErrExist or fs.ErrPermission, or nil if the reply is not about a file.
+func (e *Error) fileReason() error {
+	switch e.Code {
+	case StatusFileActionIgnored, StatusFileUnavailable, StatusBadFileName:
+	default:
+		return nil
+	}
+
+	// The code does not tell why the file is unavailable, the message
+	// usually does
+	msg := strings.ToLower(e.Msg)
+	switch {
+	case strings.Contains(msg, "permission") || strings.Contains(msg, "denied") || strings.Contains(msg, "not allowed"):
+		return fs.ErrPermission
+	case strings.Contains(msg, "no such") || strings.Contains(msg, "not found") || strings.Contains(msg, "not exist"):
+		return fs.ErrNotExist
+	case strings.Contains(msg, "exists"):
+		return fs.ErrExist
+	case e.Code == StatusBadFileName:
+		return fs.ErrPermission
+	}
+	return fs.ErrNotExist
+}
+
+// replyError returns err as an *Error if it is a negative reply to command
+func replyError(command string, err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return &Error{Command: command, Code: protoErr.Code, Msg: protoErr.Msg}
+	}
+	return err
+}
+
+// commandName returns the name of the command, without its arguments
+func commandName(format string, args ...interface{}) string {
+	line := fmt.Sprintf(format, args...)
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(line)
+}
@@ -0,0 +1,79 @@
+package ftp
+
+import (
+	"errors"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorIs(t *testing.T) {
+	for _, tt := range []struct {
+		code    int
+		msg     string
+		matches []error
+	}{
+		{StatusFileUnavailable, "/file: No such file or directory", []error{ErrNotFound}},
+		{StatusFileUnavailable, "Permission denied", []error{ErrPermission}},
+		{StatusFileUnavailable, "Directory already exists", nil},
+		{StatusFileActionIgnored, "File busy", []error{ErrTransient}},
+		{StatusNotAvailable, "Service not available", []error{ErrTransient}},
+		{StatusCanNotOpenDataConnection, "Can't open data connection", []error{ErrTransient}},
+		{StatusNotImplemented, "Command not implemented", []error{ErrNotSupported}},
+		{StatusBadCommand, "Syntax error", []error{ErrNotSupported}},
+		{StatusNotImplementedParameter, "Not implemented for that parameter", []error{ErrNotSupported}},
+		{StatusNotLoggedIn, "Login incorrect", []error{ErrAuth}},
+		{StatusInvalidCredentials, "Invalid username or password", []error{ErrAuth, ErrTransient}},
+		{StatusBadArguments, "Syntax error in parameters", nil},
+	} {
+		err := &Error{Command: "RETR", Code: tt.code, Msg: tt.msg}
+		for _, target := range []error{ErrNotFound, ErrPermission, ErrNotSupported, ErrTransient, ErrAuth} {
+			assert.Equal(t, containsError(tt.matches, target), errors.Is(err, target), "%s is %s", err, target)
+		}
+	}
+}
+
+func containsError(errs []error, target error) bool {
+	for _, err := range errs {
+		if err == target {
+			return true
+		}
+	}
+	return false
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := &Error{Command: "RETR", Code: StatusFileUnavailable, Msg: "No such file"}
+	assert.Equal(t, "RETR: 550 No such file", err.Error())
+
+	err = &Error{Code: StatusNotAvailable, Msg: "Too many users"}
+	assert.Equal(t, "421 Too many users", err.Error())
+
+	var protoErr *textproto.Error
+	require.ErrorAs(t, err, &protoErr)
+	assert.Equal(t, StatusNotAvailable, protoErr.Code)
+	assert.Equal(t, "Too many users", protoErr.Msg)
+}
+
+func TestErrorFromServer(t *testing.T) {
+	server := newTestServer(t)
+	c := dialTestServer(t, server)
+	server.handle("PASS", func(sess *testSession, arg string) {
+		sess.reply("530 Login incorrect")
+	})
+
+	_, err := c.Retr("/missing")
+	var ftpErr *Error
+	require.ErrorAs(t, err, &ftpErr)
+	assert.Equal(t, "RETR", ftpErr.Command)
+	assert.Equal(t, StatusFileUnavailable, ftpErr.Code)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = c.Login("anonymous", "secret")
+	require.ErrorAs(t, err, &ftpErr)
+	assert.Equal(t, "PASS", ftpErr.Command)
+	assert.ErrorIs(t, err, ErrAuth)
+	assert.NotContains(t, err.Error(), "secret")
+}
@@ -0,0 +1,385 @@
+package ftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FS provides access to the file tree of a FTP server through the io/fs
+// interfaces. It implements fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS,
+// as well as methods modifying the tree modeled after the os package.
+//
+// The names are relative to the root directory given to NewFS, "." being the
+// root directory itself.
+//
+// Since a ServerConn only supports one data connection at a time, the files
+// are read into memory when opened, and the operations are serialized. FS is
+// safe to be called concurrently, but the ServerConn must not be used
+// directly while the FS is in use. While a file is open for writing, the
+// other operations fail instead of waiting for it to be closed.
+type FS struct {
+	c    *ServerConn
+	root string
+
+	mu   sync.Mutex
+	busy bool // a file is open for writing
+}
+
+// errBusy is returned by the operations of a FS while a file is open for
+// writing, since its ServerConn is busy with the upload.
+var errBusy = errors.New("ftp: busy with a file open for writing")
+
+// NewFS returns a FS exposing the file tree under the root directory.
+func NewFS(c *ServerConn, root string) *FS {
+	return &FS{
+		c:    c,
+		root: path.Clean("/" + root),
+	}
+}
+
+// fullPath returns the remote path of name, or an error if name is not valid
+func (fsys *FS) fullPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(fsys.root, name), nil
+}
+
+// lock locks the FS, unless a file is open for writing
+func (fsys *FS) lock(op, name string) error {
+	fsys.mu.Lock()
+	if fsys.busy {
+		fsys.mu.Unlock()
+		return &fs.PathError{Op: op, Path: name, Err: errBusy}
+	}
+	return nil
+}
+
+// Open opens the named file or directory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	full, err := fsys.fullPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fsys.lock("open", name); err != nil {
+		return nil, err
+	}
+	defer fsys.mu.Unlock()
+
+	info, err := fsys.stat(full, name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	if info.IsDir() {
+		return &dirFile{fsys: fsys, full: full, name: name, info: info}, nil
+	}
+
+	data, err := fsys.readFile(full)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	return &file{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+// Stat returns a fs.FileInfo describing the named file.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	full, err := fsys.fullPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fsys.lock("stat", name); err != nil {
+		return nil, err
+	}
+	defer fsys.mu.Unlock()
+
+	info, err := fsys.stat(full, name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return info, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := fsys.fullPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fsys.lock("readdir", name); err != nil {
+		return nil, err
+	}
+	defer fsys.mu.Unlock()
+
+	entries, err := fsys.readDir(full)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	return entries, nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	full, err := fsys.fullPath("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fsys.lock("readfile", name); err != nil {
+		return nil, err
+	}
+	defer fsys.mu.Unlock()
+
+	data, err := fsys.readFile(full)
+	if err != nil {
+		return nil, pathError("readfile", name, err)
+	}
+	return data, nil
+}
+
+// stat returns the fileInfo of the remote path
+func (fsys *FS) stat(full, name string) (*fileInfo, error) {
+	e, err := fsys.c.stat(full)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), e: e}, nil
+}
+
+// readDir lists the remote directory
+func (fsys *FS) readDir(full string) ([]fs.DirEntry, error) {
+	entries, err := fsys.c.List(full)
+	if err != nil {
+		return nil, err
+	}
+
+	dirEntries := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		// Some servers list the members of a file with a path, such as
+		// QGPL/QCLSRC.FILE/ABC.MBR on OS/400
+		name := path.Base(e.Name)
+		if name == "." || name == ".." || name == "/" {
+			continue
+		}
+		dirEntries = append(dirEntries, &fileInfo{name: name, e: e})
+	}
+
+	sort.Slice(dirEntries, func(i, j int) bool {
+		return dirEntries[i].Name() < dirEntries[j].Name()
+	})
+
+	return dirEntries, nil
+}
+
+// readFile downloads the remote file into memory
+func (fsys *FS) readFile(full string) ([]byte, error) {
+	r, err := fsys.c.Retr(full)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if errClose := r.Close(); err == nil {
+		err = errClose
+	}
+	return data, err
+}
+
+// pathError returns a *fs.PathError wrapping err. The negative replies of the
+// server match fs.ErrNotExist, fs.ErrExist or fs.ErrPermission.
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: fsError(err)}
+}
+
+// fsError wraps a negative reply of the server so that it matches
+// fs.ErrNotExist, fs.ErrExist or fs.ErrPermission.
+func fsError(err error) error {
+	if kind := fsErrorKind(err); kind != nil {
+		return fmt.Errorf("%w: %w", kind, err)
+	}
+	return err
+}
+
+// isNotExist reports whether err means that the file does not exist
+func isNotExist(err error) bool {
+	return errors.Is(fsError(err), fs.ErrNotExist)
+}
+
+// fsErrorKind returns the fs error matching a negative reply of the server,
+// or nil.
+func fsErrorKind(err error) error {
+	var ftpErr *Error
+	if !errors.As(err, &ftpErr) {
+		return nil
+	}
+
+	if errors.Is(ftpErr, ErrAuth) {
+		return fs.ErrPermission
+	}
+	return ftpErr.fileReason()
+}
+
+// fileInfo describes an Entry as a fs.FileInfo and a fs.DirEntry
+type fileInfo struct {
+	name string
+	e    *Entry
+}
+
+// FileInfo returns a fs.FileInfo describing the entry, named after the last
+// element of its name. Its Sys method returns the entry.
+func (e *Entry) FileInfo() fs.FileInfo {
+	return &fileInfo{name: path.Base(e.Name), e: e}
+}
+
+// DirEntry returns a fs.DirEntry describing the entry, named after the last
+// element of its name.
+func (e *Entry) DirEntry() fs.DirEntry {
+	return &fileInfo{name: path.Base(e.Name), e: e}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return int64(fi.e.Size)
+}
+
+// Mode returns the type and the permissions of the entry. Only the type bits
+// are set when the listing does not tell the permissions.
+func (fi *fileInfo) Mode() fs.FileMode {
+	switch fi.e.Type {
+	case EntryTypeFolder:
+		return fs.ModeDir | fi.e.Perm
+	case EntryTypeLink:
+		return fs.ModeSymlink | fi.e.Perm
+	default:
+		return fi.e.Perm
+	}
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.e.Time
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.e.Type == EntryTypeFolder
+}
+
+// Sys returns the underlying *Entry
+func (fi *fileInfo) Sys() any {
+	return fi.e
+}
+
+func (fi *fileInfo) Type() fs.FileMode {
+	return fi.Mode().Type()
+}
+
+func (fi *fileInfo) Info() (fs.FileInfo, error) {
+	return fi, nil
+}
+
+// file is a regular file of a FS, read into memory
+type file struct {
+	*bytes.Reader
+	info   *fileInfo
+	closed bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	return f.info, nil
+}
+
+func (f *file) Read(buf []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	return f.Reader.Read(buf)
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// dirFile is a directory of a FS, listed on the first call to ReadDir
+type dirFile struct {
+	fsys    *FS
+	full    string
+	name    string
+	info    *fileInfo
+	entries []fs.DirEntry
+	listed  bool
+	closed  bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: d.name, Err: fs.ErrClosed}
+	}
+	return d.info, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	if !d.listed {
+		if err := d.fsys.lock("readdir", d.name); err != nil {
+			return nil, err
+		}
+		entries, err := d.fsys.readDir(d.full)
+		d.fsys.mu.Unlock()
+		if err != nil {
+			return nil, pathError("readdir", d.name, err)
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *dirFile) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,120 @@
+package ftp
+
+import (
+	"io/fs"
+	"path"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFS(t *testing.T) *testServer {
+	server := newTestServer(t)
+	server.addFile("/root/hello.txt", []byte("hello, world\n"))
+	server.addFile("/root/dir/file", []byte(testData))
+	server.addFile("/root/dir/sub/empty", nil)
+	server.addDir("/root/emptydir")
+	server.addFile("/outside", []byte("outside"))
+	return server
+}
+
+func TestFS(t *testing.T) {
+	server := newTestFS(t)
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	err := fstest.TestFS(fsys, "hello.txt", "dir/file", "dir/sub/empty", "emptydir")
+	assert.NoError(t, err)
+}
+
+func TestFSWithoutMLST(t *testing.T) {
+	server := newTestFS(t)
+	server.handle("FEAT", func(sess *testSession, arg string) {
+		sess.reply("211-Features:\r\n SIZE\r\n EPSV\r\n211 End")
+	})
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	err := fstest.TestFS(fsys, "hello.txt", "dir/file", "dir/sub/empty", "emptydir")
+	assert.NoError(t, err)
+}
+
+func TestFSErrors(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestFS(t)
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	_, err := fsys.Open("missing")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	_, err = fs.ReadFile(fsys, "dir/missing")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	_, err = fs.Stat(fsys, "../outside")
+	assert.ErrorIs(err, fs.ErrInvalid)
+
+	data, err := fs.ReadFile(fsys, "hello.txt")
+	assert.NoError(err)
+	assert.Equal("hello, world\n", string(data))
+
+	matches, err := fs.Glob(fsys, "dir/*")
+	assert.NoError(err)
+	assert.Equal([]string{"dir/file", "dir/sub"}, matches)
+}
+
+func TestFSMemberNames(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/root/QCLSRC.FILE/ABC.MBR", []byte("abc"))
+	server.handle("MLSD", func(sess *testSession, arg string) {
+		if path.Clean(arg) != "/root/QCLSRC.FILE" {
+			sess.list("MLSD", arg)
+			return
+		}
+		// The members are listed with a path, like OS/400 does
+		sess.send([]byte("type=file;size=3;modify=20201213202400; QGPL/QCLSRC.FILE/ABC.MBR\r\n"))
+	})
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	var names []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		names = append(names, name)
+		return err
+	})
+	assert.NoError(err)
+	assert.Equal([]string{".", "QCLSRC.FILE", "QCLSRC.FILE/ABC.MBR"}, names)
+}
+
+func TestEntryFileInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	e := &Entry{Name: "bin", Type: EntryTypeFolder, Size: 3, Time: testModTime, Perm: 0o750}
+	info := e.FileInfo()
+	assert.Equal("bin", info.Name())
+	assert.Equal(int64(3), info.Size())
+	assert.Equal(fs.ModeDir|0o750, info.Mode())
+	assert.Equal(testModTime, info.ModTime())
+	assert.True(info.IsDir())
+	assert.Same(e, info.Sys())
+
+	d := e.DirEntry()
+	assert.Equal("bin", d.Name())
+	assert.True(d.IsDir())
+	assert.Equal(fs.ModeDir, d.Type())
+	dirInfo, err := d.Info()
+	assert.NoError(err)
+	assert.Equal(info.Mode(), dirInfo.Mode())
+
+	link := &Entry{Name: "lib", Type: EntryTypeLink, Target: "usr/lib"}
+	assert.Equal(fs.ModeSymlink, link.FileInfo().Mode(), "unknown permissions")
+	assert.Equal(fs.ModeSymlink, link.DirEntry().Type())
+
+	file := &Entry{Name: "run.sh", Type: EntryTypeFile, Perm: 0o755 | fs.ModeSetuid}
+	assert.Equal(0o755|fs.ModeSetuid, file.FileInfo().Mode())
+	assert.True(file.FileInfo().Mode().IsRegular())
+	assert.Equal(fs.FileMode(0), (&Entry{Name: "a.txt"}).FileInfo().Mode())
+
+	// Some listings have paths rather than names
+	full := &Entry{Name: "/pub/docs/a.txt"}
+	assert.Equal("a.txt", full.FileInfo().Name())
+	assert.Equal("a.txt", full.DirEntry().Name())
+}
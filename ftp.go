@@ -1,6 +1,7 @@
 // Package ftp implements a FTP client as described in RFC 959.
 //
-// A textproto.Error is returned for errors at the protocol level.
+// An *Error is returned for errors at the protocol level. It can be matched
+// with errors.Is against sentinels such as ErrNotFound and ErrTransient.
 package ftp
 
 import (
@@ -8,7 +9,9 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/textproto"
 	"strconv"
@@ -52,6 +55,14 @@ type ServerConn struct {
 	conn    *textproto.Conn // connection wrapper for text protocol
 	netConn net.Conn        // underlying network connection
 	host    string
+	addr    string // address given to Dial
+
+	// Session state restored by reconnect, see DialWithReconnect
+	user         string
+	password     string
+	loggedIn     bool
+	cwd          string
+	transferType TransferType
 
 	// Server capabilities discovered at runtime
 	features      map[string]string
@@ -61,6 +72,19 @@ type ServerConn struct {
 	mdtmSupported bool
 	mdtmCanWrite  bool
 	usePRET       bool
+	skipEPRT      bool
+	hashSelected  HashAlgorithm // set with OPTS HASH
+
+	watcher  *ctxWatcher // context of the operation in progress, if any
+	response *Response   // download in progress, if any
+	broken   error       // set when the connection is left in an unknown state
+
+	transferCommand string       // last command opening a data connection
+	transferReply   string       // reply to that command
+	transferStart   time.Time    // time at which that command was sent
+	operation       *operation   // innermost operation reported to the hooks
+	transfer        *operation   // transfer reported to the hooks
+	rateLimiter     *RateLimiter // limits the data connections, if not nil
 }
 
 // DialOption represents an option to start a new connection with Dial
@@ -80,9 +104,20 @@ type dialOptions struct {
 	writingMDTM     bool
 	forceListHidden bool
 	location        *time.Location
+	listParsers     []ListParser
 	debugOutput     io.Writer
+	logger          connLogger // see DialWithLogger
+	logData         bool
+	hooks           Hooks
 	dialFunc        func(network, address string) (net.Conn, error)
 	shutTimeout     time.Duration // time to wait for data connection closing status
+	retryPolicy     *RetryPolicy
+	activeMode      bool
+	activePortFirst int
+	activePortLast  int
+	activeIP        net.IP // address announced in active mode
+	progress        ProgressFunc
+	rateLimiter     *RateLimiter
 }
 
 // Entry describes a file and is returned by List().
@@ -92,13 +127,28 @@ type Entry struct {
 	Type   EntryType
 	Size   uint64
 	Time   time.Time
+	Perm   fs.FileMode       // permission bits, with the setuid, setgid and sticky bits
+	Owner  string            // owner name or id
+	Group  string            // group name or id
+	Links  uint64            // number of hard links, 0 if unknown
+	Raw    string            // line of the listing describing the entry
+	Facts  map[string]string // facts of MLSD and MLST, with lower case names
 }
 
 // Response represents a data-connection
 type Response struct {
-	conn   net.Conn
-	c      *ServerConn
-	closed bool
+	conn    net.Conn
+	c       *ServerConn
+	closed  bool
+	eof     bool            // the whole data has been read
+	path    string          // file being retrieved, used to resume the transfer
+	offset  uint64          // offset of the next byte to read
+	resumes int             // number of times the transfer was resumed
+	read    int64           // bytes read from the data connections
+	watcher *ctxWatcher     // context watched until the response is closed
+	release func(err error) // called once closed, with the error of Close
+
+	progress *progressTracker
 }
 
 // Dial connects to the specified address with optional options
@@ -112,6 +162,11 @@ func Dial(addr string, options ...DialOption) (*ServerConn, error) {
 		do.location = time.UTC
 	}
 
+	return dial(addr, do)
+}
+
+// dial connects to the specified address with the options set by Dial
+func dial(addr string, do *dialOptions) (*ServerConn, error) {
 	dialFunc := do.dialFunc
 
 	if dialFunc == nil {
@@ -153,13 +208,16 @@ func Dial(addr string, options ...DialOption) (*ServerConn, error) {
 
 	c := &ServerConn{
 		options:  do,
+		addr:     addr,
 		features: make(map[string]string),
 		conn:     textproto.NewConn(do.wrapConn(tconn)),
 		netConn:  tconn,
 		host:     remoteAddr.IP.String(),
+
+		rateLimiter: do.rateLimiter,
 	}
 
-	_, _, err = c.conn.ReadResponse(StatusReady)
+	_, _, err = c.readResponse("", StatusReady)
 	if err != nil {
 		_ = c.Quit()
 		return nil, err
@@ -264,6 +322,15 @@ func DialWithLocation(location *time.Location) DialOption {
 	}}
 }
 
+// DialWithListParsers returns a DialOption that sets the parsers of the response to the LIST command,
+// tried in order on each line, instead of DefaultListParsers.
+// The response to the MLSD command, which is standardized, is always parsed by the built-in parser.
+func DialWithListParsers(parsers ...ListParser) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.listParsers = parsers
+	}}
+}
+
 // DialWithContext returns a DialOption that configures the ServerConn with specified context
 // The context will be used for the initial connection setup
 func DialWithContext(ctx context.Context) DialOption {
@@ -294,6 +361,9 @@ func DialWithExplicitTLS(tlsConfig *tls.Config) DialOption {
 
 // DialWithDebugOutput returns a DialOption that configures the ServerConn to write to the Writer
 // everything it reads from the server
+//
+// The output includes the password sent with PASS and the contents of the
+// files. DialWithLogger records the activity without them.
 func DialWithDebugOutput(w io.Writer) DialOption {
 	return DialOption{func(do *dialOptions) {
 		do.debugOutput = w
@@ -312,6 +382,39 @@ func DialWithDialFunc(f func(network, address string) (net.Conn, error)) DialOpt
 	}}
 }
 
+// DialWithActiveMode returns a DialOption that configures the ServerConn to use
+// active mode (EPRT, or PORT as a fallback) for data connections.
+//
+// In active mode the client listens on a local port and the server connects
+// back to it. This is useful for servers behind firewalls which only permit
+// active data connections.
+func DialWithActiveMode(enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.activeMode = enabled
+	}}
+}
+
+// DialWithActivePortRange returns a DialOption that restricts the local ports
+// the ServerConn listens on in active mode to the range [first, last].
+// By default, the port is chosen by the operating system.
+func DialWithActivePortRange(first, last int) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.activePortFirst = first
+		do.activePortLast = last
+	}}
+}
+
+// DialWithActiveExternalIP returns a DialOption that configures the IP address
+// announced to the server in active mode.
+//
+// This is useful when the client is behind a NAT. By default, the local address
+// of the control connection is announced.
+func DialWithActiveExternalIP(ip net.IP) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.activeIP = ip
+	}}
+}
+
 func (o *dialOptions) wrapConn(netConn net.Conn) io.ReadWriteCloser {
 	if o.debugOutput == nil {
 		return netConn
@@ -360,7 +463,11 @@ func (c *ServerConn) Login(user, password string) error {
 			return err
 		}
 	default:
-		return errors.New(message)
+		return &Error{Command: "USER", Code: code, Msg: message}
+	}
+
+	if c.options.retryPolicy != nil {
+		c.user, c.password, c.loggedIn = user, password, true
 	}
 
 	// Probe features
@@ -467,7 +574,7 @@ func (c *ServerConn) setUTF8() error {
 	}
 
 	if code != StatusCommandOK {
-		return errors.New(message)
+		return &Error{Command: "OPTS", Code: code, Msg: message}
 	}
 
 	return nil
@@ -574,7 +681,11 @@ func (c *ServerConn) openDataConn() (net.Conn, error) {
 
 	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	if c.options.dialFunc != nil {
-		return c.options.dialFunc("tcp", addr)
+		conn, err := c.options.dialFunc("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.limitConn(conn), nil
 	}
 
 	if c.options.tlsConfig != nil {
@@ -594,27 +705,216 @@ func (c *ServerConn) openDataConn() (net.Conn, error) {
 		if err != nil {
 			return nil, err
 		}
-		tlsConn := tls.Client(conn, c.options.tlsConfig)
+		tlsConn := tls.Client(c.limitConn(conn), c.options.tlsConfig)
 		return tlsConn, nil
 	}
 
-	return c.options.dialer.Dial("tcp", addr)
+	conn, err := c.options.dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.limitConn(conn), nil
+}
+
+// listenDataConn opens a local listener for an active mode data connection
+// and announces its address to the server.
+func (c *ServerConn) listenDataConn() (net.Listener, error) {
+	host, _, err := net.SplitHostPort(c.netConn.LocalAddr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	ip := c.options.activeIP
+	if ip == nil {
+		if ip = net.ParseIP(host); ip == nil {
+			return nil, errors.New("invalid local address for active mode")
+		}
+	}
+
+	l, err := c.listenActivePort(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.announceDataConn(ip, l.Addr().(*net.TCPAddr).Port); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// listenActivePort listens on the first available port of the configured
+// active port range, or on a port chosen by the system if there is none.
+func (c *ServerConn) listenActivePort(host string) (net.Listener, error) {
+	first, last := c.options.activePortFirst, c.options.activePortLast
+	if first == 0 && last == 0 {
+		return net.Listen("tcp", net.JoinHostPort(host, "0"))
+	}
+
+	err := errors.New("invalid active port range")
+	for port := first; port <= last; port++ {
+		var l net.Listener
+		if l, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port))); err == nil {
+			return l, nil
+		}
+	}
+	return nil, err
+}
+
+// announceDataConn tells the server where to connect for the next data
+// connection. It uses the best available method to do so.
+func (c *ServerConn) announceDataConn(ip net.IP, port int) error {
+	if !c.skipEPRT {
+		err := c.eprt(ip, port)
+		if err == nil {
+			return nil
+		}
+
+		// PORT only supports IPv4
+		if ip.To4() == nil {
+			return err
+		}
+
+		// if there is an error, skip EPRT for the next attempts
+		c.skipEPRT = true
+	}
+
+	return c.port(ip, port)
+}
+
+// eprt issues an "EPRT" command to announce the address of a data connection.
+// EPRT is described in RFC 2428
+func (c *ServerConn) eprt(ip net.IP, port int) error {
+	proto := 2
+	if ip.To4() != nil {
+		proto = 1
+	}
+
+	_, _, err := c.cmd(StatusCommandOK, "EPRT |%d|%s|%d|", proto, ip.String(), port)
+	return err
+}
+
+// port issues a "PORT" command to announce the address of a data connection.
+func (c *ServerConn) port(ip net.IP, port int) error {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return errors.New("PORT requires an IPv4 address")
+	}
+
+	_, _, err := c.cmd(StatusCommandOK, "PORT %d,%d,%d,%d,%d,%d", ip4[0], ip4[1], ip4[2], ip4[3], port/256, port%256)
+	return err
+}
+
+// acceptDataConn waits for the server to connect to the active mode listener.
+// The listener is closed once the connection is accepted.
+func (c *ServerConn) acceptDataConn(l net.Listener) (net.Conn, error) {
+	defer l.Close()
+
+	timeout := c.options.dialer.Timeout
+	if timeout == 0 {
+		timeout = DefaultDialTimeout
+	}
+	if tl, ok := l.(*net.TCPListener); ok {
+		if err := tl.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	var conn net.Conn
+	for {
+		var err error
+		if conn, err = l.Accept(); err != nil {
+			return nil, err
+		}
+		if c.isServerAddr(conn.RemoteAddr()) {
+			break
+		}
+
+		// Only the server may connect, another host could inject data
+		_ = conn.Close()
+	}
+	conn = c.limitConn(conn)
+
+	if c.options.tlsConfig != nil {
+		// The client is always the TLS client, even if the server opened the
+		// connection. See openDataConn() about the deferred Handshake.
+		return tls.Client(conn, c.options.tlsConfig), nil
+	}
+
+	return conn, nil
+}
+
+// isServerAddr reports whether addr has the IP address of the server of the
+// control connection. It is true when the addresses can not be compared.
+func (c *ServerConn) isServerAddr(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	serverIP := net.ParseIP(c.host)
+	if !ok || serverIP == nil {
+		return true
+	}
+	return tcpAddr.IP.Equal(serverIP)
 }
 
 // cmd is a helper function to execute a command and check for the expected FTP
 // return code
 func (c *ServerConn) cmd(expected int, format string, args ...interface{}) (int, string, error) {
+	if err := c.ensureConn(); err != nil {
+		return 0, "", err
+	}
+
+	command := commandName(format, args...)
+	op := c.startOperation(OperationCommand, command)
+	start := time.Now()
 	_, err := c.conn.Cmd(format, args...)
 	if err != nil {
+		op.end(0, err)
+		c.markLost(err)
 		return 0, "", err
 	}
 
-	return c.conn.ReadResponse(expected)
+	code, msg, err := c.readResponse(command, expected)
+	c.logCommand(start, code, err, format, args...)
+	op.end(0, err)
+	c.markLost(err)
+	return code, msg, err
+}
+
+// readResponse reads the reply to command, returning an *Error if its code is
+// not the expected one.
+func (c *ServerConn) readResponse(command string, expected int) (int, string, error) {
+	code, msg, err := c.conn.ReadResponse(expected)
+	c.operation.setCode(code)
+	return code, msg, replyError(command, err)
 }
 
 // cmdDataConnFrom executes a command which require a FTP data connection.
 // Issues a REST FTP command to specify the number of bytes to skip for the transfer.
 func (c *ServerConn) cmdDataConnFrom(offset uint64, format string, args ...interface{}) (net.Conn, error) {
+	if err := c.ensureConn(); err != nil {
+		return nil, err
+	}
+
+	command := commandName(format, args...)
+	op := c.startOperation(OperationDataConn, command)
+	conn, err := c.openDataConnFor(offset, format, args...)
+	op.end(0, err)
+	if err != nil {
+		return nil, err
+	}
+	conn = c.logDataConn(conn)
+	c.startTransfer(command)
+
+	if c.watcher != nil {
+		c.watcher.setDataConn(conn)
+	}
+
+	return conn, nil
+}
+
+// openDataConnFor opens a data connection, in passive or active mode, for the
+// given command.
+func (c *ServerConn) openDataConnFor(offset uint64, format string, args ...interface{}) (net.Conn, error) {
 	// If server requires PRET send the PRET command to warm it up
 	// See: https://tools.ietf.org/html/draft-dd-pret-00
 	if c.usePRET {
@@ -624,46 +924,87 @@ func (c *ServerConn) cmdDataConnFrom(offset uint64, format string, args ...inter
 		}
 	}
 
+	if c.options.activeMode {
+		l, err := c.listenDataConn()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.dataCmd(offset, format, args...); err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+
+		return c.acceptDataConn(l)
+	}
+
 	conn, err := c.openDataConn()
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.dataCmd(offset, format, args...); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// dataCmd issues the optional REST command and the command which starts a
+// transfer on the data connection.
+func (c *ServerConn) dataCmd(offset uint64, format string, args ...interface{}) error {
 	if offset != 0 {
-		_, _, err = c.cmd(StatusRequestFilePending, "REST %d", offset)
+		_, _, err := c.cmd(StatusRequestFilePending, "REST %d", offset)
 		if err != nil {
-			_ = conn.Close()
-			return nil, err
+			return err
 		}
 	}
 
-	_, err = c.conn.Cmd(format, args...)
+	command := commandName(format, args...)
+	op := c.startOperation(OperationCommand, command)
+	start := time.Now()
+	_, err := c.conn.Cmd(format, args...)
 	if err != nil {
-		_ = conn.Close()
-		return nil, err
+		op.end(0, err)
+		return err
 	}
 
-	code, msg, err := c.conn.ReadResponse(-1)
-	if err != nil {
-		_ = conn.Close()
-		return nil, err
+	code, msg, err := c.readResponse(command, -1)
+	if err == nil && code != StatusAlreadyOpen && code != StatusAboutToSend {
+		err = &Error{Command: command, Code: code, Msg: msg}
 	}
-	if code != StatusAlreadyOpen && code != StatusAboutToSend {
-		_ = conn.Close()
-		return nil, &textproto.Error{Code: code, Msg: msg}
+	c.logCommand(start, code, err, format, args...)
+	op.end(0, err)
+	if err != nil {
+		return err
 	}
 
-	return conn, nil
+	c.transferCommand = command
+	c.transferReply = msg
+	c.transferStart = start
+	return nil
 }
 
 // Type switches the transfer mode for the connection.
 func (c *ServerConn) Type(transferType TransferType) (err error) {
 	_, _, err = c.cmd(StatusCommandOK, "TYPE %s", string(transferType))
+	if err == nil {
+		c.transferType = transferType
+	}
 	return err
 }
 
 // NameList issues an NLST FTP command.
 func (c *ServerConn) NameList(path string) (entries []string, err error) {
+	err = c.retry(func() error {
+		entries, err = c.nameList(path)
+		return err
+	})
+	return entries, err
+}
+
+func (c *ServerConn) nameList(path string) (entries []string, err error) {
 	space := " "
 	if path == "" {
 		space = ""
@@ -675,7 +1016,7 @@ func (c *ServerConn) NameList(path string) (entries []string, err error) {
 
 	var errs []error
 
-	r := &Response{conn: conn, c: c}
+	r := &Response{conn: conn, c: c, progress: c.newProgress(path, DirectionDownload, 0, -1)}
 
 	scanner := bufio.NewScanner(c.options.wrapStream(r))
 	for scanner.Scan() {
@@ -693,19 +1034,43 @@ func (c *ServerConn) NameList(path string) (entries []string, err error) {
 }
 
 // List issues a LIST FTP command.
+// The lines of the response which cannot be parsed are ignored: see
+// ListWithSkipped to get them.
 func (c *ServerConn) List(path string) (entries []*Entry, err error) {
+	result, err := c.ListWithSkipped(path)
+	if result != nil {
+		entries = result.Entries
+	}
+	return entries, err
+}
+
+// ListWithSkipped issues a LIST FTP command like List, and also returns the
+// lines of the response which could not be parsed, along with the parse
+// errors. The summary lines such as "total 42" are not reported.
+func (c *ServerConn) ListWithSkipped(path string) (result *ListResult, err error) {
+	err = c.retry(func() error {
+		result, err = c.list(path)
+		return err
+	})
+	return result, err
+}
+
+func (c *ServerConn) list(path string) (*ListResult, error) {
 	var cmd string
-	var parser parseFunc
+	var parsers []ListParser
 
 	if c.mlstSupported && !c.options.forceListHidden {
 		cmd = "MLSD"
-		parser = parseRFC3659ListLine
+		parsers = []ListParser{ListParserFunc(parseRFC3659ListLine)}
 	} else {
 		cmd = "LIST"
 		if c.options.forceListHidden {
 			cmd += " -a"
 		}
-		parser = parseListLine
+		parsers = c.options.listParsers
+		if parsers == nil {
+			parsers = DefaultListParsers()
+		}
 	}
 
 	space := " "
@@ -719,33 +1084,33 @@ func (c *ServerConn) List(path string) (entries []*Entry, err error) {
 
 	var errs []error
 
-	r := &Response{conn: conn, c: c}
-
-	scanner := bufio.NewScanner(c.options.wrapStream(r))
-	now := time.Now()
-	for scanner.Scan() {
-		entry, errParse := parser(scanner.Text(), now, c.options.location)
-		if errParse == nil {
-			entries = append(entries, entry)
-		}
-	}
+	r := &Response{conn: conn, c: c, progress: c.newProgress(path, DirectionDownload, 0, -1)}
 
-	if err := scanner.Err(); err != nil {
+	result, err := parseListing(c.options.wrapStream(r), parsers, time.Now(), c.options.location)
+	if err != nil {
 		errs = append(errs, err)
 	}
 	if err := r.Close(); err != nil {
 		errs = append(errs, err)
 	}
 
-	return entries, errors.Join(errs...)
+	return result, errors.Join(errs...)
 }
 
 // GetEntry issues a MLST FTP command which retrieves one single Entry using the
 // control connection. The returnedEntry will describe the current directory
 // when no path is given.
 func (c *ServerConn) GetEntry(path string) (entry *Entry, err error) {
+	err = c.retry(func() error {
+		entry, err = c.getEntry(path)
+		return err
+	})
+	return entry, err
+}
+
+func (c *ServerConn) getEntry(path string) (entry *Entry, err error) {
 	if !c.mlstSupported {
-		return nil, &textproto.Error{Code: StatusNotImplemented, Msg: StatusText(StatusNotImplemented)}
+		return nil, &Error{Command: "MLST", Code: StatusNotImplemented, Msg: StatusText(StatusNotImplemented)}
 	}
 	space := " "
 	if path == "" {
@@ -806,7 +1171,10 @@ func (c *ServerConn) IsTimePreciseInList() bool {
 // the specified path.
 func (c *ServerConn) ChangeDir(path string) error {
 	_, _, err := c.cmd(StatusRequestedFileActionOK, "CWD %s", path)
-	return err
+	if err != nil {
+		return err
+	}
+	return c.trackDir()
 }
 
 // ChangeDirToParent issues a CDUP FTP command, which changes the current
@@ -814,7 +1182,10 @@ func (c *ServerConn) ChangeDir(path string) error {
 // with a path set to "..".
 func (c *ServerConn) ChangeDirToParent() error {
 	_, _, err := c.cmd(StatusRequestedFileActionOK, "CDUP")
-	return err
+	if err != nil {
+		return err
+	}
+	return c.trackDir()
 }
 
 // CurrentDir issues a PWD FTP command, which Returns the path of the current
@@ -836,27 +1207,36 @@ func (c *ServerConn) CurrentDir() (string, error) {
 }
 
 // FileSize issues a SIZE FTP command, which Returns the size of the file
-func (c *ServerConn) FileSize(path string) (int64, error) {
-	_, msg, err := c.cmd(StatusFile, "SIZE %s", path)
-	if err != nil {
-		return 0, err
-	}
+func (c *ServerConn) FileSize(path string) (size int64, err error) {
+	err = c.retry(func() error {
+		var msg string
+		_, msg, err = c.cmd(StatusFile, "SIZE %s", path)
+		if err != nil {
+			return err
+		}
 
-	return strconv.ParseInt(msg, 10, 64)
+		size, err = strconv.ParseInt(msg, 10, 64)
+		return err
+	})
+	return size, err
 }
 
 // GetTime issues the MDTM FTP command to obtain the file modification time.
 // It returns a UTC time.
-func (c *ServerConn) GetTime(path string) (time.Time, error) {
-	var t time.Time
+func (c *ServerConn) GetTime(path string) (t time.Time, err error) {
 	if !c.mdtmSupported {
-		return t, errors.New("GetTime is not supported")
-	}
-	_, msg, err := c.cmd(StatusFile, "MDTM %s", path)
-	if err != nil {
-		return t, err
+		return t, fmt.Errorf("%w: GetTime", ErrNotSupported)
 	}
-	return time.ParseInLocation(timeFormat, msg, time.UTC)
+	err = c.retry(func() error {
+		var msg string
+		_, msg, err = c.cmd(StatusFile, "MDTM %s", path)
+		if err != nil {
+			return err
+		}
+		t, err = time.ParseInLocation(timeFormat, msg, time.UTC)
+		return err
+	})
+	return t, err
 }
 
 // IsGetTimeSupported allows library callers to check in advance that they
@@ -877,7 +1257,7 @@ func (c *ServerConn) SetTime(path string, t time.Time) (err error) {
 	case c.mdtmCanWrite:
 		_, _, err = c.cmd(StatusFile, "MDTM %s %s", utime, path)
 	default:
-		err = errors.New("SetTime is not supported")
+		err = fmt.Errorf("%w: SetTime", ErrNotSupported)
 	}
 	return
 }
@@ -901,18 +1281,29 @@ func (c *ServerConn) Retr(path string) (*Response, error) {
 //
 // The returned ReadCloser must be closed to cleanup the FTP data connection.
 func (c *ServerConn) RetrFrom(path string, offset uint64) (*Response, error) {
-	conn, err := c.cmdDataConnFrom(offset, "RETR %s", path)
+	var conn net.Conn
+	err := c.retry(func() (err error) {
+		conn, err = c.cmdDataConnFrom(offset, "RETR %s", path)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &Response{conn: conn, c: c}, nil
+	c.response = &Response{
+		conn:     conn,
+		c:        c,
+		path:     path,
+		offset:   offset,
+		progress: c.newProgress(path, DirectionDownload, int64(offset), parseTransferSize(c.transferReply)),
+	}
+	return c.response, nil
 }
 
 // Stor issues a STOR FTP command to store a file to the remote FTP server.
 // Stor creates the specified file with the content of the io.Reader.
 //
-// Hint: io.Pipe() can be used if an io.Writer is required.
+// Hint: StorWriter can be used if an io.Writer is required.
 func (c *ServerConn) Stor(path string, r io.Reader) error {
 	return c.StorFrom(path, r, 0)
 }
@@ -925,13 +1316,30 @@ func (c *ServerConn) Stor(path string, r io.Reader) error {
 // The ShutTimeout dial option will rescue here. It will nudge the control
 // connection deadline right before checking the data closing status.
 func (c *ServerConn) checkDataShut() error {
+	if c.broken != nil {
+		return c.broken
+	}
 	if c.options.shutTimeout != 0 {
 		shutDeadline := time.Now().Add(c.options.shutTimeout)
 		if err := c.netConn.SetDeadline(shutDeadline); err != nil {
 			return err
 		}
 	}
-	_, _, err := c.conn.ReadResponse(StatusClosingDataConnection)
+
+	// The transfer was interrupted by a context, but the control connection
+	// can still be recovered by reading the status of the data connection.
+	interrupted := c.watcher.interrupted()
+	if interrupted {
+		if err := c.netConn.SetDeadline(time.Now().Add(c.recoverTimeout())); err != nil {
+			return err
+		}
+	}
+
+	code, _, err := c.readResponse(c.transferCommand, StatusClosingDataConnection)
+	c.logTransfer(code, err)
+	if err == nil && interrupted {
+		c.watcher.setRecovered()
+	}
 	return err
 }
 
@@ -939,73 +1347,93 @@ func (c *ServerConn) checkDataShut() error {
 // Stor creates the specified file with the content of the io.Reader, writing
 // on the server will start at the given file offset.
 //
-// Hint: io.Pipe() can be used if an io.Writer is required.
+// Hint: StorWriter can be used if an io.Writer is required.
 func (c *ServerConn) StorFrom(path string, r io.Reader, offset uint64) error {
-	conn, err := c.cmdDataConnFrom(offset, "STOR %s", path)
+	w, err := c.dataWriter(offset, "STOR %s", path)
 	if err != nil {
 		return err
 	}
 
-	var errs []error
-
-	// if the upload fails we still need to try to read the server
-	// response otherwise if the failure is not due to a connection problem,
-	// for example the server denied the upload for quota limits, we miss
-	// the response and we cannot use the connection to send other commands.
-	if n, err := io.Copy(conn, r); err != nil {
-		errs = append(errs, err)
-	} else if n == 0 {
-		// If we wrote no bytes and got no error, make sure we call
-		// tls.Handshake on the connection as it won't get called
-		// unless Write() is called. (See comment in openDataConn()).
-		//
-		// ProFTP doesn't like this and returns "Unable to build data
-		// connection: Operation not permitted" when trying to upload
-		// an empty file without this.
-		if do, ok := conn.(interface{ Handshake() error }); ok {
-			if err := do.Handshake(); err != nil {
-				errs = append(errs, err)
-			}
+	if c.options.progress != nil {
+		total := uploadSize(r)
+		if total >= 0 {
+			total += int64(offset)
 		}
+		progress := c.newProgress(path, DirectionUpload, int64(offset), total)
+		defer progress.finish()
+		r = withProgress(r, progress)
 	}
 
-	if err := conn.Close(); err != nil {
-		errs = append(errs, err)
-	}
-
-	if err := c.checkDataShut(); err != nil {
-		errs = append(errs, err)
-	}
-
-	return errors.Join(errs...)
+	return w.copyFrom(r)
 }
 
 // Append issues a APPE FTP command to store a file to the remote FTP server.
 // If a file already exists with the given path, then the content of the
 // io.Reader is appended. Otherwise, a new file is created with that content.
 //
-// Hint: io.Pipe() can be used if an io.Writer is required.
+// Hint: AppendWriter can be used if an io.Writer is required.
 func (c *ServerConn) Append(path string, r io.Reader) error {
-	conn, err := c.cmdDataConnFrom(0, "APPE %s", path)
+	w, err := c.dataWriter(0, "APPE %s", path)
 	if err != nil {
 		return err
 	}
 
-	var errs []error
+	if c.options.progress != nil {
+		progress := c.newProgress(path, DirectionUpload, 0, uploadSize(r))
+		defer progress.finish()
+		r = withProgress(r, progress)
+	}
 
-	if _, err := io.Copy(conn, r); err != nil {
-		errs = append(errs, err)
+	return w.copyFrom(r)
+}
+
+// StorWriter issues a STOR FTP command to store a file to the remote FTP
+// server, and returns a writer to the content of the file.
+//
+// The returned WriteCloser must be closed to complete the upload, and no
+// other command can be issued until then. Close returns the result of the
+// upload.
+func (c *ServerConn) StorWriter(path string) (io.WriteCloser, error) {
+	return c.StorWriterFrom(path, 0)
+}
+
+// StorWriterFrom is like StorWriter, but writing on the server will start at
+// the given file offset.
+func (c *ServerConn) StorWriterFrom(path string, offset uint64) (io.WriteCloser, error) {
+	w, err := c.dataWriter(offset, "STOR %s", path)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := conn.Close(); err != nil {
-		errs = append(errs, err)
+	w.progress = c.newProgress(path, DirectionUpload, int64(offset), -1)
+	return w, nil
+}
+
+// AppendWriter issues a APPE FTP command to store a file to the remote FTP
+// server, and returns a writer to the content appended to the file.
+//
+// The returned WriteCloser must be closed to complete the upload, and no
+// other command can be issued until then. Close returns the result of the
+// upload.
+func (c *ServerConn) AppendWriter(path string) (io.WriteCloser, error) {
+	w, err := c.dataWriter(0, "APPE %s", path)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := c.checkDataShut(); err != nil {
-		errs = append(errs, err)
+	w.progress = c.newProgress(path, DirectionUpload, 0, -1)
+	return w, nil
+}
+
+// dataWriter issues a command storing a file and returns a writer to the
+// data connection.
+func (c *ServerConn) dataWriter(offset uint64, format string, args ...interface{}) (*writer, error) {
+	conn, err := c.cmdDataConnFrom(offset, format, args...)
+	if err != nil {
+		return nil, err
 	}
 
-	return errors.Join(errs...)
+	return &writer{conn: conn, c: c}, nil
 }
 
 // Rename renames a file on the remote FTP server.
@@ -1106,6 +1534,9 @@ func (c *ServerConn) NoOp() error {
 // Logout issues a REIN FTP command to logout the current user.
 func (c *ServerConn) Logout() error {
 	_, _, err := c.cmd(StatusReady, "REIN")
+	if err == nil {
+		c.loggedIn = false
+	}
 	return err
 }
 
@@ -1127,16 +1558,46 @@ func (c *ServerConn) Quit() error {
 
 // Read implements the io.Reader interface on a FTP data connection.
 func (r *Response) Read(buf []byte) (int, error) {
-	return r.conn.Read(buf)
+	for {
+		n, err := r.conn.Read(buf)
+		r.offset += uint64(n)
+		r.read += int64(n)
+		r.progress.add(int64(n))
+
+		switch {
+		case err == io.EOF:
+			r.eof = true
+		case err != nil && r.resumable(err):
+			r.resumes++
+			if errResume := r.resume(); errResume != nil {
+				return n, errors.Join(err, errResume)
+			}
+			if n == 0 {
+				// Read from the resumed transfer
+				continue
+			}
+			return n, nil
+		}
+
+		return n, err
+	}
 }
 
 // Close implements the io.Closer interface on a FTP data connection.
 // After the first call, Close will do nothing and return nil.
+//
+// Close waits for the server to complete the transfer. Use Abort to stop
+// the transfer before all the data has been read.
 func (r *Response) Close() error {
 	if r.closed {
 		return nil
 	}
 
+	// Do not wait for the end of a transfer interrupted by a context
+	if !r.eof && r.c.watcher.interrupted() {
+		return r.Abort()
+	}
+
 	var errs []error
 
 	if err := r.conn.Close(); err != nil {
@@ -1147,9 +1608,26 @@ func (r *Response) Close() error {
 		errs = append(errs, err)
 	}
 
+	return r.finish(errors.Join(errs...))
+}
+
+// finish marks the response as closed and releases its resources.
+func (r *Response) finish(err error) error {
 	r.closed = true
+	r.progress.finish()
+	if r.c.response == r {
+		r.c.response = nil
+	}
 
-	return errors.Join(errs...)
+	if r.watcher != nil {
+		err = r.watcher.finish(err)
+	}
+	r.c.endTransfer(r.read, err)
+	if r.release != nil {
+		r.release(err)
+	}
+
+	return err
 }
 
 // SetDeadline sets the deadlines associated with the connection.
@@ -1157,6 +1635,106 @@ func (r *Response) SetDeadline(t time.Time) error {
 	return r.conn.SetDeadline(t)
 }
 
+// writer represents a data-connection on which a file is stored
+type writer struct {
+	conn     net.Conn
+	c        *ServerConn
+	closed   bool
+	written  int64
+	watcher  *ctxWatcher // context watched until the writer is closed
+	progress *progressTracker
+}
+
+// Write implements the io.Writer interface on a FTP data connection.
+func (w *writer) Write(buf []byte) (int, error) {
+	if w.closed {
+		return 0, net.ErrClosed
+	}
+
+	n, err := w.conn.Write(buf)
+	w.written += int64(n)
+	w.progress.add(int64(n))
+	return n, err
+}
+
+// ReadFrom implements the io.ReaderFrom interface, so that io.Copy uses the
+// io.ReaderFrom of the data connection.
+func (w *writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.closed {
+		return 0, net.ErrClosed
+	}
+
+	n, err := io.Copy(w.conn, r)
+	w.written += n
+	w.progress.add(n)
+	return n, err
+}
+
+// copyFrom writes the content of r and closes the writer. The writer is
+// closed even if the copy fails, to read the response of the server.
+func (w *writer) copyFrom(r io.Reader) error {
+	// if the upload fails we still need to try to read the server
+	// response otherwise if the failure is not due to a connection problem,
+	// for example the server denied the upload for quota limits, we miss
+	// the response and we cannot use the connection to send other commands.
+	_, err := w.ReadFrom(r)
+	return errors.Join(err, w.Close())
+}
+
+// Close implements the io.Closer interface on a FTP data connection. It
+// waits for the server to store the file and returns the result.
+// After the first call, Close will do nothing and return nil.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.progress.finish()
+
+	// An upload interrupted by a context is aborted, so that the server
+	// does not store the partial file as if it was complete
+	if w.c.watcher.interrupted() {
+		return w.finish(w.abort())
+	}
+
+	var errs []error
+
+	if w.written == 0 {
+		// If we wrote no bytes, make sure we call tls.Handshake on the
+		// connection as it won't get called unless Write() is called.
+		// (See comment in openDataConn()).
+		//
+		// ProFTP doesn't like this and returns "Unable to build data
+		// connection: Operation not permitted" when trying to upload
+		// an empty file without this.
+		if do, ok := w.conn.(interface{ Handshake() error }); ok {
+			if err := do.Handshake(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := w.conn.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := w.c.checkDataShut(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return w.finish(errors.Join(errs...))
+}
+
+// finish stops watching the context of the writer and returns the result of
+// the upload.
+func (w *writer) finish(err error) error {
+	if w.watcher != nil {
+		err = w.watcher.finish(err)
+	}
+	w.c.endTransfer(w.written, err)
+	return err
+}
+
 // String returns the string representation of EntryType t.
 func (t EntryType) String() string {
 	return [...]string{"file", "folder", "link"}[t]
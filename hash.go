@@ -0,0 +1,253 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// HashAlgorithm is a checksum algorithm computed by the server
+type HashAlgorithm string
+
+// Hash algorithms, named as in the HASH command
+const (
+	HashCRC32  HashAlgorithm = "CRC32"
+	HashMD5    HashAlgorithm = "MD5"
+	HashSHA1   HashAlgorithm = "SHA-1"
+	HashSHA256 HashAlgorithm = "SHA-256"
+	HashSHA512 HashAlgorithm = "SHA-512"
+)
+
+// ErrChecksumMismatch is returned when the checksum of a transferred file
+// does not match the checksum of its source.
+var ErrChecksumMismatch = errors.New("ftp: checksum mismatch")
+
+// hashAlgorithms are the implementations of the hash algorithms
+var hashAlgorithms = map[HashAlgorithm]func() hash.Hash{
+	HashCRC32:  func() hash.Hash { return crc32.NewIEEE() },
+	HashMD5:    md5.New,
+	HashSHA1:   sha1.New,
+	HashSHA256: sha256.New,
+	HashSHA512: sha512.New,
+}
+
+// legacyHashCommands are the non-standard commands computing a checksum, in
+// order of preference.
+var legacyHashCommands = []struct {
+	algo    HashAlgorithm
+	command string
+}{
+	{HashSHA512, "XSHA512"},
+	{HashSHA256, "XSHA256"},
+	{HashSHA1, "XSHA1"},
+	{HashMD5, "XMD5"},
+	{HashCRC32, "XCRC"},
+}
+
+// NewHash returns a hash.Hash computing the given algorithm, or nil if it is
+// unknown.
+func (algo HashAlgorithm) NewHash() hash.Hash {
+	newHash, ok := hashAlgorithms[algo]
+	if !ok {
+		return nil
+	}
+	return newHash()
+}
+
+// parseHashFeature parses the description of the HASH feature, e.g.
+// "SHA-1;SHA-256*;MD5", where the selected algorithm is marked with a star.
+func parseHashFeature(desc string) (algos []HashAlgorithm, selected HashAlgorithm) {
+	for _, name := range strings.Split(desc, ";") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		name, isSelected := strings.CutSuffix(name, "*")
+		algo := HashAlgorithm(strings.ToUpper(name))
+		algos = append(algos, algo)
+		if isSelected {
+			selected = algo
+		}
+	}
+
+	return algos, selected
+}
+
+// HashAlgorithms returns the hash algorithms supported by the server, as
+// announced by FEAT for the HASH command and for the legacy XSHA512,
+// XSHA256, XSHA1, XMD5 and XCRC commands.
+func (c *ServerConn) HashAlgorithms() []HashAlgorithm {
+	var algos []HashAlgorithm
+	if desc, ok := c.features["HASH"]; ok {
+		algos, _ = parseHashFeature(desc)
+	}
+
+	for _, legacy := range legacyHashCommands {
+		if _, ok := c.features[legacy.command]; ok && !containsHashAlgorithm(algos, legacy.algo) {
+			algos = append(algos, legacy.algo)
+		}
+	}
+
+	return algos
+}
+
+// preferredHash returns the algorithm used to verify the transfers: the one
+// selected for the HASH command, or the best one of the legacy commands. It
+// returns an empty string if the server does not support any.
+func (c *ServerConn) preferredHash() HashAlgorithm {
+	if desc, ok := c.features["HASH"]; ok {
+		algos, selected := parseHashFeature(desc)
+		if c.hashSelected != "" {
+			selected = c.hashSelected
+		}
+		if selected.NewHash() != nil {
+			return selected
+		}
+		for _, algo := range algos {
+			if algo.NewHash() != nil {
+				return algo
+			}
+		}
+	}
+
+	for _, legacy := range legacyHashCommands {
+		if _, ok := c.features[legacy.command]; ok {
+			return legacy.algo
+		}
+	}
+
+	return ""
+}
+
+// Hash returns the checksum of the file computed by the server with the
+// given algorithm.
+//
+// It uses the HASH command, selecting the algorithm with "OPTS HASH" if
+// needed, when the server announces the algorithm for it. Otherwise it falls
+// back to the legacy XSHA512, XSHA256, XSHA1, XMD5 and XCRC commands.
+// See https://datatracker.ietf.org/doc/html/draft-bryan-ftpext-hash-02
+func (c *ServerConn) Hash(path string, algo HashAlgorithm) ([]byte, error) {
+	if desc, ok := c.features["HASH"]; ok {
+		algos, selected := parseHashFeature(desc)
+		if containsHashAlgorithm(algos, algo) {
+			return c.hash(path, algo, selected)
+		}
+	}
+
+	for _, legacy := range legacyHashCommands {
+		if legacy.algo == algo {
+			return c.legacyHash(path, legacy.command, algo.NewHash().Size())
+		}
+	}
+
+	return nil, fmt.Errorf("%w: hash algorithm %s", ErrNotSupported, algo)
+}
+
+// hash issues a HASH FTP command, selecting the algorithm first if needed
+func (c *ServerConn) hash(path string, algo, selected HashAlgorithm) ([]byte, error) {
+	if c.hashSelected != "" {
+		selected = c.hashSelected
+	}
+	if algo != selected {
+		if _, _, err := c.cmd(StatusCommandOK, "OPTS HASH %s", algo); err != nil {
+			return nil, err
+		}
+		c.hashSelected = algo
+	}
+
+	_, msg, err := c.cmd(StatusFile, "HASH %s", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reply is "<algorithm> <start>-<end> <checksum> <path>"
+	fields := strings.SplitN(msg, " ", 4)
+	if len(fields) < 3 || !strings.EqualFold(fields[0], string(algo)) {
+		return nil, fmt.Errorf("invalid HASH response: %s", msg)
+	}
+
+	sum, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid HASH response: %s", msg)
+	}
+
+	return sum, nil
+}
+
+// legacyHash issues one of the legacy commands computing a checksum of the
+// given size
+func (c *ServerConn) legacyHash(path, command string, size int) ([]byte, error) {
+	code, msg, err := c.cmd(-1, "%s %s", command, path)
+	if err != nil {
+		return nil, err
+	}
+	if code < 200 || code >= 300 {
+		return nil, &Error{Command: command, Code: code, Msg: msg}
+	}
+
+	// The servers do not agree on the format of the reply: look for the
+	// field which is an hexadecimal checksum
+	for _, field := range strings.Fields(msg) {
+		if sum, err := hex.DecodeString(field); err == nil && len(sum) == size {
+			return sum, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid %s response: %s", command, msg)
+}
+
+// VerifyHash compares the checksum of the remote file computed by the server
+// with the checksum of the data read from r. It returns an error wrapping
+// ErrChecksumMismatch if they differ.
+func (c *ServerConn) VerifyHash(path string, algo HashAlgorithm, r io.Reader) error {
+	h := algo.NewHash()
+	if h == nil {
+		return fmt.Errorf("%w: hash algorithm %s", ErrNotSupported, algo)
+	}
+
+	remote, err := c.Hash(path, algo)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+
+	if local := h.Sum(nil); !bytes.Equal(local, remote) {
+		return fmt.Errorf("%w: %s checksum of %s is %x, expected %x", ErrChecksumMismatch, algo, path, remote, local)
+	}
+
+	return nil
+}
+
+// verifyHash compares the checksum of the remote file with the one of r,
+// using the preferred algorithm of the server. It does nothing if the server
+// does not support any.
+func (c *ServerConn) verifyHash(path string, r io.Reader) error {
+	algo := c.preferredHash()
+	if algo == "" {
+		return nil
+	}
+
+	return c.VerifyHash(path, algo, r)
+}
+
+func containsHashAlgorithm(algos []HashAlgorithm, algo HashAlgorithm) bool {
+	for _, a := range algos {
+		if a == algo {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHashFeature(t *testing.T) {
+	algos, selected := parseHashFeature("SHA-1;sha-256*;MD5;")
+	assert.Equal(t, []HashAlgorithm{HashSHA1, HashSHA256, HashMD5}, algos)
+	assert.Equal(t, HashSHA256, selected)
+}
+
+func TestHash(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+
+	var commands []string
+	for _, command := range []string{"OPTS", "HASH", "XMD5", "XCRC"} {
+		command := command
+		server.handle(command, func(sess *testSession, arg string) {
+			commands = append(commands, strings.TrimSpace(command+" "+strings.Split(arg, " ")[0]))
+			sess.builtin(command, arg)
+		})
+	}
+	c := dialTestServer(t, server)
+	commands = nil
+
+	assert.Equal([]HashAlgorithm{HashSHA256, HashSHA1, HashMD5, HashCRC32}, c.HashAlgorithms())
+
+	sha256Sum := sha256.Sum256([]byte(testData))
+	sum, err := c.Hash("/file", HashSHA256)
+	assert.NoError(err)
+	assert.Equal(sha256Sum[:], sum)
+
+	// Switching the algorithm selects it with OPTS HASH once
+	sha1Sum := sha1.Sum([]byte(testData))
+	for i := 0; i < 2; i++ {
+		sum, err = c.Hash("/file", HashSHA1)
+		assert.NoError(err)
+		assert.Equal(sha1Sum[:], sum)
+	}
+
+	assert.Equal([]string{"HASH /file", "OPTS HASH", "HASH /file", "HASH /file"}, commands)
+	assert.NoError(c.VerifyHash("/file", HashMD5, strings.NewReader(testData)))
+
+	err = c.VerifyHash("/file", HashSHA1, strings.NewReader("other data"))
+	assert.ErrorIs(err, ErrChecksumMismatch)
+
+	_, err = c.Hash("/file", "SHA-384")
+	assert.Error(err)
+}
+
+func TestHashLegacy(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	server.handle("FEAT", func(sess *testSession, arg string) {
+		sess.reply("211-Features:\r\n SIZE\r\n XCRC\r\n XMD5\r\n211 End")
+	})
+	c := dialTestServer(t, server)
+
+	assert.Equal([]HashAlgorithm{HashMD5, HashCRC32}, c.HashAlgorithms())
+	assert.Equal(HashMD5, c.preferredHash())
+
+	md5Sum := md5.Sum([]byte(testData))
+	sum, err := c.Hash("/file", HashMD5)
+	assert.NoError(err)
+	assert.Equal(md5Sum[:], sum)
+
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write([]byte(testData))
+	sum, err = c.Hash("/file", HashCRC32)
+	assert.NoError(err)
+	assert.Equal(crc.Sum(nil), sum)
+
+	// The upload is verified with the legacy command
+	require.NoError(t, c.Stor("/copy", bytes.NewBufferString(testData)))
+	assert.NoError(c.verifyHash("/copy", strings.NewReader(testData)))
+	assert.ErrorIs(c.verifyHash("/copy", strings.NewReader("other data")), ErrChecksumMismatch)
+}
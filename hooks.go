@@ -0,0 +1,174 @@
+package ftp
+
+import (
+	"context"
+	"time"
+)
+
+// Hooks receives the start and the end of the operations of a ServerConn, so
+// that metrics and traces can be collected without parsing the logs. They are
+// installed with DialWithHooks.
+//
+// The methods are called synchronously by the goroutine using the ServerConn,
+// and concurrently for different connections. They must not use the
+// ServerConn.
+type Hooks interface {
+	// OperationStart is called when an operation starts. ctx is the context
+	// of the enclosing operation, or the one given to a method such as
+	// RetrContext. The returned context is passed to OperationEnd and to the
+	// operations started until then, which allows to attach a span to it.
+	OperationStart(ctx context.Context, op *Operation) context.Context
+
+	// OperationEnd is called when the operation ends, once the fields
+	// describing its result have been set.
+	OperationEnd(ctx context.Context, op *Operation)
+}
+
+// OperationKind is the kind of an Operation
+type OperationKind int
+
+// Kinds of operations
+const (
+	// OperationCommand is a command sent on the control connection, ending
+	// with the reply of the server.
+	OperationCommand OperationKind = iota
+
+	// OperationDataConn is the setup of a data connection, from the
+	// negotiation of its address to the reply to the command using it.
+	OperationDataConn
+
+	// OperationTransfer is a transfer on a data connection, ending when the
+	// Response or the writer is closed.
+	OperationTransfer
+)
+
+// String returns the string representation of the OperationKind k.
+func (k OperationKind) String() string {
+	return [...]string{"command", "data connection", "transfer"}[k]
+}
+
+// Operation describes an operation of a ServerConn
+type Operation struct {
+	Kind OperationKind
+
+	// Command is the name of the command, without its arguments. For a data
+	// connection or a transfer, it is the command using the data connection.
+	Command string
+
+	// Direction is the direction of a transfer
+	Direction Direction
+
+	Start time.Time
+
+	// The following fields are set when the operation ends
+
+	Duration time.Duration
+	Code     int   // code of the last reply, 0 if none was received
+	Bytes    int64 // bytes transferred on the data connection
+	Err      error
+}
+
+// DialWithHooks returns a DialOption that installs hooks called at the start
+// and at the end of the commands, data connections and transfers.
+func DialWithHooks(hooks Hooks) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.hooks = hooks
+	}}
+}
+
+// operation is an Operation in progress, nil when there are no hooks
+type operation struct {
+	c      *ServerConn
+	ctx    context.Context
+	parent *operation // enclosing operation
+	op     Operation
+	ended  bool
+}
+
+// startOperation calls the hooks for the start of an operation, which becomes
+// the current operation of the ServerConn.
+func (c *ServerConn) startOperation(kind OperationKind, command string) *operation {
+	if c.options.hooks == nil {
+		return nil
+	}
+
+	o := &operation{
+		c:      c,
+		parent: c.operation,
+		op: Operation{
+			Kind:    kind,
+			Command: command,
+			Start:   time.Now(),
+		},
+	}
+	if kind == OperationTransfer {
+		o.op.Direction = transferDirection(command)
+	}
+
+	o.ctx = c.options.hooks.OperationStart(c.hookContext(), &o.op)
+	c.operation = o
+	return o
+}
+
+// hookContext returns the context given to the hooks for a new operation
+func (c *ServerConn) hookContext() context.Context {
+	switch {
+	case c.operation != nil:
+		return c.operation.ctx
+	case c.watcher != nil:
+		return c.watcher.ctx
+	default:
+		return context.Background()
+	}
+}
+
+// setCode records the code of a reply received during the operation.
+// It is safe to call on a nil operation.
+func (o *operation) setCode(code int) {
+	if o != nil {
+		o.op.Code = code
+	}
+}
+
+// end calls the hooks for the end of the operation. It is safe to call on a
+// nil operation, and does nothing after the first call.
+func (o *operation) end(bytes int64, err error) {
+	if o == nil || o.ended {
+		return
+	}
+	o.ended = true
+
+	o.op.Duration = time.Since(o.op.Start)
+	o.op.Bytes = bytes
+	o.op.Err = err
+
+	o.c.operation = o.parent
+	if o.op.Code != 0 {
+		o.parent.setCode(o.op.Code)
+	}
+	o.c.options.hooks.OperationEnd(o.ctx, &o.op)
+}
+
+// startTransfer starts the transfer operation for command, unless a transfer
+// being resumed is already in progress.
+func (c *ServerConn) startTransfer(command string) {
+	if c.transfer == nil {
+		c.transfer = c.startOperation(OperationTransfer, command)
+	}
+}
+
+// endTransfer calls the hooks for the end of the current transfer
+func (c *ServerConn) endTransfer(bytes int64, err error) {
+	c.transfer.end(bytes, err)
+	c.transfer = nil
+}
+
+// transferDirection returns the direction of the transfer of command
+func transferDirection(command string) Direction {
+	switch command {
+	case "STOR", "STOU", "APPE":
+		return DirectionUpload
+	default:
+		return DirectionDownload
+	}
+}
@@ -0,0 +1,191 @@
+package ftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type hookParentKey struct{}
+
+// recordedOperation is an Operation received by testHooks
+type recordedOperation struct {
+	Operation
+	parent *recordedOperation
+	ctx    context.Context
+}
+
+// testHooks records the operations which ended
+type testHooks struct {
+	mu    sync.Mutex
+	ended []*recordedOperation
+}
+
+func (h *testHooks) OperationStart(ctx context.Context, op *Operation) context.Context {
+	parent, _ := ctx.Value(hookParentKey{}).(*recordedOperation)
+	return context.WithValue(ctx, hookParentKey{}, &recordedOperation{parent: parent, ctx: ctx})
+}
+
+func (h *testHooks) OperationEnd(ctx context.Context, op *Operation) {
+	rec := ctx.Value(hookParentKey{}).(*recordedOperation)
+	rec.Operation = *op
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ended = append(h.ended, rec)
+}
+
+// find returns the last ended operation of the given kind and command
+func (h *testHooks) find(kind OperationKind, command string) *recordedOperation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := len(h.ended) - 1; i >= 0; i-- {
+		if op := h.ended[i]; op.Kind == kind && op.Command == command {
+			return op
+		}
+	}
+	return nil
+}
+
+func TestHooksRetr(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte("file contents"))
+
+	hooks := &testHooks{}
+	c := dialTestServer(t, server, DialWithHooks(hooks))
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	dataConn := hooks.find(OperationDataConn, "RETR")
+	require.NotNil(t, dataConn)
+	assert.NoError(t, dataConn.Err)
+	assert.Equal(t, StatusAboutToSend, dataConn.Code)
+	assert.Nil(t, dataConn.parent)
+
+	retr := hooks.find(OperationCommand, "RETR")
+	require.NotNil(t, retr)
+	assert.Equal(t, StatusAboutToSend, retr.Code)
+	assert.Same(t, dataConn, retr.parent, "RETR is sent while setting up the data connection")
+
+	transfer := hooks.find(OperationTransfer, "RETR")
+	require.NotNil(t, transfer)
+	assert.NoError(t, transfer.Err)
+	assert.Equal(t, DirectionDownload, transfer.Direction)
+	assert.Equal(t, StatusClosingDataConnection, transfer.Code)
+	assert.EqualValues(t, len("file contents"), transfer.Bytes)
+	assert.Positive(t, transfer.Duration)
+}
+
+func TestHooksStor(t *testing.T) {
+	server := newTestServer(t)
+	hooks := &testHooks{}
+	c := dialTestServer(t, server, DialWithHooks(hooks))
+
+	require.NoError(t, c.Stor("/upload", strings.NewReader("uploaded contents")))
+
+	transfer := hooks.find(OperationTransfer, "STOR")
+	require.NotNil(t, transfer)
+	assert.NoError(t, transfer.Err)
+	assert.Equal(t, DirectionUpload, transfer.Direction)
+	assert.Equal(t, StatusClosingDataConnection, transfer.Code)
+	assert.EqualValues(t, len("uploaded contents"), transfer.Bytes)
+}
+
+func TestHooksError(t *testing.T) {
+	server := newTestServer(t)
+	hooks := &testHooks{}
+	c := dialTestServer(t, server, DialWithHooks(hooks))
+
+	_, err := c.FileSize("/missing")
+	require.Error(t, err)
+
+	size := hooks.find(OperationCommand, "SIZE")
+	require.NotNil(t, size)
+	assert.Equal(t, StatusFileUnavailable, size.Code)
+	assert.ErrorIs(t, size.Err, ErrNotFound)
+}
+
+func TestHooksContext(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte("file contents"))
+
+	hooks := &testHooks{}
+	c := dialTestServer(t, server, DialWithHooks(hooks))
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+
+	_, err := c.FileSizeContext(ctx, "/file")
+	require.NoError(t, err)
+
+	size := hooks.find(OperationCommand, "SIZE")
+	require.NotNil(t, size)
+	assert.Equal(t, "value", size.ctx.Value(key{}))
+}
+
+func TestHooksResumedRetr(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	server.handle("RETR", failOnce("RETR", func(sess *testSession, arg string) {
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+
+		sess.reply("150 Opening data connection")
+		_, _ = conn.Write([]byte(testData[:5]))
+
+		// Lose both the data and the control connections
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+		_ = sess.conn.Close()
+	}))
+
+	hooks := &testHooks{}
+	c := dialTestServer(t, server, DialWithHooks(hooks), DialWithReconnect(testRetryPolicy))
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, testData, string(buf))
+	require.NoError(t, r.Close())
+	assert.Equal(t, 2, server.connCount())
+
+	// The resumed transfer is a single operation
+	var transfers []*recordedOperation
+	for _, op := range hooks.ended {
+		if op.Kind == OperationTransfer {
+			transfers = append(transfers, op)
+		}
+	}
+	if assert.Len(t, transfers, 1) {
+		assert.NoError(t, transfers[0].Err)
+		assert.EqualValues(t, len(testData), transfers[0].Bytes)
+	}
+
+	// The data connection of the resumption and the commands of the
+	// reconnection are part of it
+	dataConn := hooks.find(OperationDataConn, "RETR")
+	require.NotNil(t, dataConn)
+	assert.Same(t, transfers[0], dataConn.parent)
+	user := hooks.find(OperationCommand, "USER")
+	require.NotNil(t, user)
+	parent := user.parent
+	for parent != nil && parent != transfers[0] {
+		parent = parent.parent
+	}
+	assert.Same(t, transfers[0], parent)
+}
@@ -0,0 +1,98 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// connLogger records the activity of a ServerConn, see DialWithLogger
+type connLogger interface {
+	// logCommand records a command and the reply of the server
+	logCommand(line string, code int, latency time.Duration, err error)
+
+	// logDataConn records the opening of a data connection for command
+	logDataConn(command string, active bool, conn net.Conn)
+
+	// logTransfer records the end of the transfer started by command
+	logTransfer(command string, code int, latency time.Duration, err error)
+
+	// logData records the contents of a data connection
+	logData(command string, dir Direction, data []byte)
+}
+
+// redactedCommands are the commands whose arguments are never logged
+var redactedCommands = map[string]bool{
+	"PASS": true,
+	"ACCT": true,
+}
+
+// commandLine returns the command line to be logged, with the credentials
+// redacted.
+func commandLine(format string, args ...interface{}) string {
+	if name := commandName(format, args...); redactedCommands[name] {
+		return name + " ****"
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// logCommand records a command sent at start and its reply
+func (c *ServerConn) logCommand(start time.Time, code int, err error, format string, args ...interface{}) {
+	if c.options.logger == nil {
+		return
+	}
+	c.options.logger.logCommand(commandLine(format, args...), code, time.Since(start), err)
+}
+
+// logDataConn records the opening of a data connection, and returns it
+// wrapped to record its contents if enabled with DialWithDataLogging.
+func (c *ServerConn) logDataConn(conn net.Conn) net.Conn {
+	if c.options.logger == nil {
+		return conn
+	}
+
+	c.options.logger.logDataConn(c.transferCommand, c.options.activeMode, conn)
+	if !c.options.logData {
+		return conn
+	}
+	return &loggedConn{Conn: conn, logger: c.options.logger, command: c.transferCommand}
+}
+
+// logTransfer records the final reply of the current transfer
+func (c *ServerConn) logTransfer(code int, err error) {
+	if c.options.logger == nil {
+		return
+	}
+	c.options.logger.logTransfer(c.transferCommand, code, time.Since(c.transferStart), err)
+}
+
+// loggedConn is a data connection whose contents are logged
+type loggedConn struct {
+	net.Conn
+	logger  connLogger
+	command string
+}
+
+func (lc *loggedConn) Read(buf []byte) (int, error) {
+	n, err := lc.Conn.Read(buf)
+	if n > 0 {
+		lc.logger.logData(lc.command, DirectionDownload, buf[:n])
+	}
+	return n, err
+}
+
+func (lc *loggedConn) Write(buf []byte) (int, error) {
+	n, err := lc.Conn.Write(buf)
+	if n > 0 {
+		lc.logger.logData(lc.command, DirectionUpload, buf[:n])
+	}
+	return n, err
+}
+
+// Handshake runs the TLS handshake of the underlying connection, if any
+func (lc *loggedConn) Handshake() error {
+	if tlsConn, ok := lc.Conn.(interface{ Handshake() error }); ok {
+		return tlsConn.Handshake()
+	}
+	return nil
+}
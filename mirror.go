@@ -0,0 +1,777 @@
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrTooManyDeletes is returned when a mirror plan deletes more files than
+// allowed with MirrorWithMaxDeletes.
+var ErrTooManyDeletes = errors.New("ftp: too many deletions")
+
+// MirrorDirection tells which side of a mirror is updated
+type MirrorDirection int
+
+// Mirror directions
+const (
+	// MirrorUpload updates the remote tree to match the local one. It is
+	// the default.
+	MirrorUpload MirrorDirection = iota
+
+	// MirrorDownload updates the local tree to match the remote one.
+	MirrorDownload
+
+	// MirrorBoth copies the files missing on each side, and replaces the
+	// files which differ with the most recently modified one. Nothing is
+	// deleted, since a file missing on one side can not be told apart from
+	// a file created on the other side.
+	MirrorBoth
+)
+
+// MirrorComparison tells how a mirror finds the files which differ
+type MirrorComparison int
+
+// Mirror comparisons
+const (
+	// MirrorCompareSizeTime compares the sizes and the modification times,
+	// to the second. It is the default.
+	MirrorCompareSizeTime MirrorComparison = iota
+
+	// MirrorCompareChecksum compares the sizes and the checksums, computed
+	// by the server with the algorithm used by Hash. The modification times
+	// are only used to choose the most recent file with MirrorBoth.
+	MirrorCompareChecksum
+)
+
+// MirrorActionKind is the kind of a MirrorAction
+type MirrorActionKind int
+
+// Kinds of mirror actions
+const (
+	MirrorCreate MirrorActionKind = iota
+	MirrorUpdate
+	MirrorDelete
+)
+
+// String returns the string representation of the MirrorActionKind k.
+func (k MirrorActionKind) String() string {
+	return [...]string{"create", "update", "delete"}[k]
+}
+
+// MirrorSide is a side of a mirror
+type MirrorSide int
+
+// Sides of a mirror
+const (
+	MirrorLocal MirrorSide = iota
+	MirrorRemote
+)
+
+// String returns the string representation of the MirrorSide s.
+func (s MirrorSide) String() string {
+	return [...]string{"local", "remote"}[s]
+}
+
+// MirrorAction is a change to one side of a mirror
+type MirrorAction struct {
+	Kind MirrorActionKind
+
+	// Side is the side which is changed. The file is copied from the other
+	// side when it is created or updated.
+	Side MirrorSide
+
+	// Path is the slash-separated path of the file, relative to the
+	// mirrored directories.
+	Path string
+
+	Dir bool
+
+	// Size and ModTime describe the file which is copied
+	Size    int64
+	ModTime time.Time
+}
+
+// String returns a description of the action, such as "update remote a/b".
+func (a MirrorAction) String() string {
+	return fmt.Sprintf("%s %s %s", a.Kind, a.Side, a.Path)
+}
+
+// MirrorPlan is the list of actions synchronizing a local directory tree with
+// a remote one. It is computed by Mirror.Plan and applied by Mirror.Execute.
+type MirrorPlan struct {
+	Local   string
+	Remote  string
+	Actions []MirrorAction
+}
+
+// Deletes returns the number of deletions of the plan
+func (p *MirrorPlan) Deletes() int {
+	n := 0
+	for _, a := range p.Actions {
+		if a.Kind == MirrorDelete {
+			n++
+		}
+	}
+	return n
+}
+
+// MirrorOption represents an option of a Mirror created with NewMirror
+type MirrorOption struct {
+	setup func(mo *mirrorOptions)
+}
+
+// mirrorOptions contains all the options set by MirrorOption.setup
+type mirrorOptions struct {
+	direction       MirrorDirection
+	comparison      MirrorComparison
+	delete          bool
+	maxDeletes      int
+	journal         string
+	transferOptions []TransferOption
+	transfer        *transferOptions
+}
+
+// MirrorWithDirection returns a MirrorOption that sets which side of the
+// mirror is updated.
+func MirrorWithDirection(direction MirrorDirection) MirrorOption {
+	return MirrorOption{func(mo *mirrorOptions) {
+		mo.direction = direction
+	}}
+}
+
+// MirrorWithComparison returns a MirrorOption that sets how the files which
+// differ are found.
+func MirrorWithComparison(comparison MirrorComparison) MirrorOption {
+	return MirrorOption{func(mo *mirrorOptions) {
+		mo.comparison = comparison
+	}}
+}
+
+// MirrorWithDelete returns a MirrorOption that enables the deletion of the
+// files and directories which only exist on the updated side. It is disabled
+// by default.
+func MirrorWithDelete(enabled bool) MirrorOption {
+	return MirrorOption{func(mo *mirrorOptions) {
+		mo.delete = enabled
+	}}
+}
+
+// MirrorWithMaxDeletes returns a MirrorOption that makes Mirror.Execute
+// refuse a plan deleting more than n files and directories, as a protection
+// against an empty or wrong source directory. Zero means no limit.
+func MirrorWithMaxDeletes(n int) MirrorOption {
+	return MirrorOption{func(mo *mirrorOptions) {
+		mo.maxDeletes = n
+	}}
+}
+
+// MirrorWithJournal returns a MirrorOption that makes Mirror.Execute record
+// the plan and its progress in a local file, so that Mirror.Resume can
+// complete an interrupted execution. The journal is removed once the plan is
+// completed.
+func MirrorWithJournal(name string) MirrorOption {
+	return MirrorOption{func(mo *mirrorOptions) {
+		mo.journal = name
+	}}
+}
+
+// MirrorWithTransferOptions returns a MirrorOption that sets the options of
+// the transfers. The files are selected with TransferWithInclude and
+// TransferWithExclude, and the other options are given to DownloadFile and
+// UploadFile.
+func MirrorWithTransferOptions(options ...TransferOption) MirrorOption {
+	return MirrorOption{func(mo *mirrorOptions) {
+		mo.transferOptions = append(mo.transferOptions, options...)
+	}}
+}
+
+// Mirror synchronizes a local directory tree with a remote one. The files are
+// compared, using the facts of MLSD when the server supports it, to make a
+// plan which can be inspected before being executed.
+//
+// The symbolic links are ignored. A directory listed by MLSD with the unique
+// fact of a directory already found, such as a link the server lists as a
+// directory, is only mirrored once.
+type Mirror struct {
+	local   string
+	remote  string
+	options *mirrorOptions
+}
+
+// NewMirror returns a Mirror of the local directory and the remote one.
+func NewMirror(local, remote string, options ...MirrorOption) *Mirror {
+	mo := &mirrorOptions{}
+	for _, option := range options {
+		option.setup(mo)
+	}
+	mo.transfer = newTransferOptions(mo.transferOptions)
+
+	return &Mirror{
+		local:   local,
+		remote:  remote,
+		options: mo,
+	}
+}
+
+// Run makes a plan and executes it.
+func (m *Mirror) Run(c *ServerConn) error {
+	plan, err := m.Plan(c)
+	if err != nil {
+		return err
+	}
+	return m.Execute(c, plan)
+}
+
+// mirrorFile describes a file or a directory of one side of a mirror
+type mirrorFile struct {
+	dir   bool
+	size  int64
+	mtime time.Time // zero until known, see resolveModTime
+}
+
+// Plan compares the local and the remote trees, and returns the actions
+// synchronizing them. It returns ErrUnsafePath if a name of the remote
+// listings would escape the local directory.
+func (m *Mirror) Plan(c *ServerConn) (*MirrorPlan, error) {
+	local, err := m.scanLocal()
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := m.scanRemote(c)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(local)+len(remote))
+	for name := range local {
+		names = append(names, name)
+	}
+	for name := range remote {
+		if _, ok := local[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// A file and a directory which are not replaced are left as is, with
+	// the content of the directory
+	conflicts := make(map[string]bool)
+
+	var deletes, copies []MirrorAction
+	for _, name := range names {
+		if conflicts[path.Dir(name)] {
+			conflicts[name] = true
+			continue
+		}
+
+		l, r := local[name], remote[name]
+		actions, err := m.compare(c, name, l, r)
+		if err != nil {
+			return nil, err
+		}
+		if actions == nil && l != nil && r != nil && l.dir != r.dir {
+			conflicts[name] = true
+		}
+
+		for _, a := range actions {
+			if a.Kind == MirrorDelete {
+				deletes = append(deletes, a)
+			} else {
+				copies = append(copies, a)
+			}
+		}
+	}
+
+	// The deletions are done first, the content of the directories before
+	// the directories themselves
+	sort.SliceStable(deletes, func(i, j int) bool {
+		return deletes[i].Path > deletes[j].Path
+	})
+
+	return &MirrorPlan{
+		Local:   m.local,
+		Remote:  m.remote,
+		Actions: append(deletes, copies...),
+	}, nil
+}
+
+// compare returns the actions synchronizing the file on both sides
+func (m *Mirror) compare(c *ServerConn, name string, local, remote *mirrorFile) ([]MirrorAction, error) {
+	if m.options.direction == MirrorBoth {
+		return m.compareBoth(c, name, local, remote)
+	}
+
+	src, dst, side := local, remote, MirrorRemote
+	if m.options.direction == MirrorDownload {
+		src, dst, side = remote, local, MirrorLocal
+	}
+
+	switch {
+	case src == nil:
+		if m.options.delete {
+			return []MirrorAction{mirrorAction(MirrorDelete, side, name, dst)}, nil
+		}
+		return nil, nil
+	case dst == nil:
+		return []MirrorAction{mirrorAction(MirrorCreate, side, name, src)}, nil
+	case src.dir != dst.dir:
+		if m.options.delete {
+			return []MirrorAction{
+				mirrorAction(MirrorDelete, side, name, dst),
+				mirrorAction(MirrorCreate, side, name, src),
+			}, nil
+		}
+		return nil, nil
+	case src.dir:
+		return nil, nil
+	}
+
+	differ, err := m.differ(c, name, local, remote)
+	if err != nil || !differ {
+		return nil, err
+	}
+	return []MirrorAction{mirrorAction(MirrorUpdate, side, name, src)}, nil
+}
+
+// compareBoth returns the actions synchronizing the file on both sides, with
+// MirrorBoth
+func (m *Mirror) compareBoth(c *ServerConn, name string, local, remote *mirrorFile) ([]MirrorAction, error) {
+	switch {
+	case remote == nil:
+		return []MirrorAction{mirrorAction(MirrorCreate, MirrorRemote, name, local)}, nil
+	case local == nil:
+		return []MirrorAction{mirrorAction(MirrorCreate, MirrorLocal, name, remote)}, nil
+	case local.dir || remote.dir:
+		return nil, nil
+	}
+
+	differ, err := m.differ(c, name, local, remote)
+	if err != nil || !differ {
+		return nil, err
+	}
+	if err := m.resolveModTime(c, name, remote); err != nil {
+		return nil, err
+	}
+
+	// The most recent file wins, and a conflict is left as is
+	switch local.mtime.Truncate(time.Second).Compare(remote.mtime.Truncate(time.Second)) {
+	case 1:
+		return []MirrorAction{mirrorAction(MirrorUpdate, MirrorRemote, name, local)}, nil
+	case -1:
+		return []MirrorAction{mirrorAction(MirrorUpdate, MirrorLocal, name, remote)}, nil
+	}
+	return nil, nil
+}
+
+func mirrorAction(kind MirrorActionKind, side MirrorSide, name string, f *mirrorFile) MirrorAction {
+	return MirrorAction{
+		Kind:    kind,
+		Side:    side,
+		Path:    name,
+		Dir:     f.dir,
+		Size:    f.size,
+		ModTime: f.mtime,
+	}
+}
+
+// differ reports whether the local and the remote files differ
+func (m *Mirror) differ(c *ServerConn, name string, local, remote *mirrorFile) (bool, error) {
+	if local.size != remote.size {
+		return true, nil
+	}
+
+	if m.options.comparison == MirrorCompareSizeTime {
+		if err := m.resolveModTime(c, name, remote); err != nil {
+			return false, err
+		}
+		return !local.mtime.Truncate(time.Second).Equal(remote.mtime.Truncate(time.Second)), nil
+	}
+
+	algo := c.preferredHash()
+	if algo == "" {
+		return false, fmt.Errorf("%w: checksum comparison", ErrNotSupported)
+	}
+
+	remoteSum, err := c.Hash(m.remotePath(name), algo)
+	if err != nil {
+		return false, err
+	}
+
+	localName, err := m.localPath(name)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(localName)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := algo.NewHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+
+	return !bytes.Equal(h.Sum(nil), remoteSum), nil
+}
+
+// resolveModTime gets the modification time of the remote file with GetTime,
+// if the listing does not have a precise one.
+func (m *Mirror) resolveModTime(c *ServerConn, name string, remote *mirrorFile) error {
+	if !remote.mtime.IsZero() {
+		return nil
+	}
+
+	mtime, err := c.GetTime(m.remotePath(name))
+	if err != nil {
+		return err
+	}
+	remote.mtime = mtime
+	return nil
+}
+
+// localPath returns the local path of a file of the tree, or an error if the
+// name would escape the local directory or go through a symbolic link
+func (m *Mirror) localPath(name string) (string, error) {
+	rel := filepath.FromSlash(name)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+
+	linked, err := hasSymlink(m.local, name)
+	if err != nil {
+		return "", err
+	}
+	if linked {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+
+	return filepath.Join(m.local, rel), nil
+}
+
+func (m *Mirror) remotePath(name string) string {
+	return path.Join(m.remote, name)
+}
+
+// scanLocal lists the local tree
+func (m *Mirror) scanLocal() (map[string]*mirrorFile, error) {
+	files := make(map[string]*mirrorFile)
+	err := filepath.WalkDir(m.local, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == m.local && errors.Is(err, fs.ErrNotExist) {
+				// Nothing was mirrored yet
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(m.local, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.Type()&fs.ModeSymlink != 0 || m.options.transfer.excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		files[rel] = &mirrorFile{dir: info.IsDir(), size: info.Size(), mtime: info.ModTime()}
+		return nil
+	})
+
+	return files, err
+}
+
+// scanRemote lists the remote tree. The modification times of the files are
+// left to be resolved with GetTime if the listing does not have precise ones.
+func (m *Mirror) scanRemote(c *ServerConn) (map[string]*mirrorFile, error) {
+	preciseTimes := c.mlstSupported && !c.options.forceListHidden
+	if !c.IsGetTimeSupported() {
+		preciseTimes = true
+	}
+
+	files := make(map[string]*mirrorFile)
+	walked := make(map[string]bool) // unique facts of the directories
+	w := c.Walk(m.remote)
+	for w.Next() {
+		e := w.Stat()
+		rel := relPath(m.remote, w.Path())
+		if !isLocalName(e.Name) || !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsafePath, w.Path())
+		}
+		dir := e.Type == EntryTypeFolder
+
+		if e.Type == EntryTypeLink || m.options.transfer.excluded(rel, dir) {
+			w.SkipDir()
+			continue
+		}
+
+		if unique := e.Facts["unique"]; dir && unique != "" {
+			// Another path to a directory already walked
+			if walked[unique] {
+				w.SkipDir()
+				continue
+			}
+			walked[unique] = true
+		}
+
+		f := &mirrorFile{dir: dir, size: int64(e.Size)}
+		if preciseTimes && !dir {
+			f.mtime = e.Time
+		}
+		files[rel] = f
+	}
+
+	if err := w.Err(); err != nil && !(isNotExist(err) && len(files) == 0) {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Execute applies the actions of the plan, stopping at the first error.
+func (m *Mirror) Execute(c *ServerConn, plan *MirrorPlan) error {
+	if m.options.maxDeletes > 0 {
+		if n := plan.Deletes(); n > m.options.maxDeletes {
+			return fmt.Errorf("%w: the plan deletes %d files, at most %d are allowed", ErrTooManyDeletes, n, m.options.maxDeletes)
+		}
+	}
+
+	j, err := createMirrorJournal(m.options.journal, plan)
+	if err != nil {
+		return err
+	}
+
+	return m.execute(c, plan, j, nil)
+}
+
+// Resume completes the execution of the plan recorded in the journal set
+// with MirrorWithJournal. It returns an error matching fs.ErrNotExist if there
+// is no journal.
+func (m *Mirror) Resume(c *ServerConn) error {
+	if m.options.journal == "" {
+		return fmt.Errorf("mirror journal: %w", fs.ErrNotExist)
+	}
+
+	plan, done, err := readMirrorJournal(m.options.journal)
+	if err != nil {
+		return err
+	}
+
+	j, err := openMirrorJournal(m.options.journal)
+	if err != nil {
+		return err
+	}
+
+	return m.execute(c, plan, j, done)
+}
+
+// execute applies the actions of the plan which are not done, recording
+// their completion in the journal.
+func (m *Mirror) execute(c *ServerConn, plan *MirrorPlan, j *mirrorJournal, done map[int]bool) error {
+	sub := &Mirror{local: plan.Local, remote: plan.Remote, options: m.options}
+	if err := sub.createRoots(c, plan); err != nil {
+		return errors.Join(err, j.close())
+	}
+
+	for i, a := range plan.Actions {
+		if done[i] {
+			continue
+		}
+
+		if err := sub.apply(c, a); err != nil {
+			return errors.Join(fmt.Errorf("%s: %w", a, err), j.close())
+		}
+		if err := j.done(i); err != nil {
+			return errors.Join(err, j.close())
+		}
+	}
+
+	return j.remove()
+}
+
+// createRoots creates the mirrored directories if files are copied into them
+func (m *Mirror) createRoots(c *ServerConn, plan *MirrorPlan) error {
+	var local, remote bool
+	for _, a := range plan.Actions {
+		if a.Kind != MirrorDelete {
+			local = local || a.Side == MirrorLocal
+			remote = remote || a.Side == MirrorRemote
+		}
+	}
+
+	if local {
+		if err := os.MkdirAll(m.local, 0o755); err != nil {
+			return err
+		}
+	}
+	if remote {
+		return c.makeDirAll(m.remote)
+	}
+	return nil
+}
+
+// apply applies an action
+func (m *Mirror) apply(c *ServerConn, a MirrorAction) error {
+	local, err := m.localPath(a.Path)
+	if err != nil {
+		return err
+	}
+	remote := m.remotePath(a.Path)
+
+	switch {
+	case a.Kind == MirrorDelete && a.Side == MirrorLocal:
+		return os.Remove(local)
+	case a.Kind == MirrorDelete && a.Dir:
+		return c.RemoveDir(remote)
+	case a.Kind == MirrorDelete:
+		return c.Delete(remote)
+	case a.Side == MirrorLocal && a.Dir:
+		return os.MkdirAll(local, 0o755)
+	case a.Side == MirrorLocal:
+		return c.downloadTreeFile(treeFile{remote: remote, local: local, mtime: a.ModTime}, m.options.transferOptions)
+	case a.Dir:
+		return c.makeDir(remote)
+	}
+
+	// The modification time is needed to compare the files later
+	options := m.options.transferOptions
+	if c.IsSetTimeSupported() {
+		options = append(options[:len(options):len(options)], TransferWithPreservedModTime(true))
+	}
+	return c.UploadFile(local, remote, options...)
+}
+
+// mirrorJournal records the execution of a mirror plan, nil if disabled
+type mirrorJournal struct {
+	name string
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// mirrorJournalRecord is a line of the journal: the plan, followed by the
+// indexes of the actions done
+type mirrorJournalRecord struct {
+	Plan *MirrorPlan `json:",omitempty"`
+	Done *int        `json:",omitempty"`
+}
+
+// createMirrorJournal creates the journal of the plan, or returns nil if name
+// is empty.
+func createMirrorJournal(name string, plan *MirrorPlan) (*mirrorJournal, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &mirrorJournal{name: name, f: f, enc: json.NewEncoder(f)}
+	if err := j.write(mirrorJournalRecord{Plan: plan}); err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+	return j, nil
+}
+
+// openMirrorJournal opens an existing journal to record more actions done
+func openMirrorJournal(name string) (*mirrorJournal, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &mirrorJournal{name: name, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// readMirrorJournal returns the plan recorded in the journal and the indexes
+// of the actions done. A truncated last line is ignored.
+func readMirrorJournal(name string) (*MirrorPlan, map[int]bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var plan *MirrorPlan
+	done := make(map[int]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var record mirrorJournalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			break
+		}
+		switch {
+		case record.Plan != nil:
+			plan = record.Plan
+		case record.Done != nil:
+			done[*record.Done] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if plan == nil {
+		return nil, nil, fmt.Errorf("mirror journal %s has no plan", name)
+	}
+	return plan, done, nil
+}
+
+// write appends a record to the journal, synced to the disk
+func (j *mirrorJournal) write(record mirrorJournalRecord) error {
+	if err := j.enc.Encode(record); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// done records that the action at index i is done
+func (j *mirrorJournal) done(i int) error {
+	if j == nil {
+		return nil
+	}
+	return j.write(mirrorJournalRecord{Done: &i})
+}
+
+// close closes the journal, keeping it for Mirror.Resume
+func (j *mirrorJournal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// remove closes and deletes the journal of a completed plan
+func (j *mirrorJournal) remove() error {
+	if j == nil {
+		return nil
+	}
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(j.name)
+}
@@ -0,0 +1,254 @@
+package ftp
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// actionStrings returns the descriptions of the actions of the plan
+func actionStrings(plan *MirrorPlan) []string {
+	var actions []string
+	for _, a := range plan.Actions {
+		actions = append(actions, a.String())
+	}
+	return actions
+}
+
+// newTestMirror returns a server whose /mirror directory differs from the
+// tree created by writeLocalTree
+func newTestMirror(t *testing.T) *testServer {
+	server := newTestServer(t)
+	server.addFile("/mirror/sub/b.txt", []byte("bb"))
+	server.addFile("/mirror/sub/c.log", []byte("changed"))
+	server.addFile("/mirror/old/file", []byte("old"))
+	return server
+}
+
+func TestMirrorUpload(t *testing.T) {
+	server := newTestMirror(t)
+	c := dialTestServer(t, server)
+	m := NewMirror(writeLocalTree(t), "/mirror", MirrorWithDelete(true))
+
+	plan, err := m.Plan(c)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"delete remote old/file",
+		"delete remote old",
+		"create remote a.txt",
+		"create remote empty",
+		"update remote sub/c.log",
+	}, actionStrings(plan))
+	assert.Equal(t, 2, plan.Deletes())
+
+	require.NoError(t, m.Execute(c, plan))
+	assert.Nil(t, server.lookup("/mirror/old"))
+	assert.Equal(t, "a", string(server.file("/mirror/a.txt")))
+	assert.Equal(t, "ccc", string(server.file("/mirror/sub/c.log")))
+	assertRemoteDir(t, server, "/mirror/empty")
+
+	plan, err = m.Plan(c)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Actions, "the trees are synchronized")
+}
+
+func TestMirrorUploadNewDir(t *testing.T) {
+	server := newTestServer(t)
+	c := dialTestServer(t, server)
+
+	require.NoError(t, NewMirror(writeLocalTree(t), "/new/mirror").Run(c))
+	assert.Equal(t, "bb", string(server.file("/new/mirror/sub/b.txt")))
+}
+
+func TestMirrorDeleteProtection(t *testing.T) {
+	server := newTestMirror(t)
+	c := dialTestServer(t, server)
+
+	m := NewMirror(writeLocalTree(t), "/mirror", MirrorWithDelete(true), MirrorWithMaxDeletes(1))
+	err := m.Run(c)
+	assert.ErrorIs(t, err, ErrTooManyDeletes)
+	assert.NotNil(t, server.lookup("/mirror/old/file"))
+	assert.Nil(t, server.lookup("/mirror/a.txt"), "nothing is done")
+
+	m = NewMirror(writeLocalTree(t), "/mirror")
+	require.NoError(t, m.Run(c))
+	assert.NotNil(t, server.lookup("/mirror/old/file"), "deletion is disabled by default")
+}
+
+func TestMirrorDownload(t *testing.T) {
+	server := newTestMirror(t)
+	c := dialTestServer(t, server)
+	local := writeLocalTree(t)
+	m := NewMirror(local, "/mirror", MirrorWithDirection(MirrorDownload), MirrorWithDelete(true))
+
+	plan, err := m.Plan(c)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"delete local empty",
+		"delete local a.txt",
+		"create local old",
+		"create local old/file",
+		"update local sub/c.log",
+	}, actionStrings(plan))
+
+	require.NoError(t, m.Execute(c, plan))
+	assert.NoFileExists(t, filepath.Join(local, "a.txt"))
+	assert.NoDirExists(t, filepath.Join(local, "empty"))
+	assertLocalFile(t, filepath.Join(local, "old", "file"), "old")
+	assertLocalFile(t, filepath.Join(local, "sub", "c.log"), "changed")
+}
+
+func TestMirrorUnsafeNames(t *testing.T) {
+	for _, direction := range []MirrorDirection{MirrorDownload, MirrorBoth} {
+		server := newTestServer(t)
+		server.addDir("/mirror")
+		server.handle("MLSD", func(sess *testSession, arg string) {
+			sess.send([]byte("type=file;size=5;modify=20201213202400; ../escape.txt\r\n"))
+		})
+		c := dialTestServer(t, server)
+
+		parent := t.TempDir()
+		m := NewMirror(filepath.Join(parent, "local"), "/mirror", MirrorWithDirection(direction))
+		_, err := m.Plan(c)
+		assert.ErrorIs(t, err, ErrUnsafePath)
+
+		// A plan from a hostile journal is not executed either
+		plan := &MirrorPlan{
+			Local:   filepath.Join(parent, "local"),
+			Remote:  "/mirror",
+			Actions: []MirrorAction{{Kind: MirrorCreate, Side: MirrorLocal, Path: "../escape.txt"}},
+		}
+		assert.ErrorIs(t, m.Execute(c, plan), ErrUnsafePath)
+		assert.NoFileExists(t, filepath.Join(parent, "escape.txt"))
+	}
+}
+
+func TestMirrorThroughLocalSymlink(t *testing.T) {
+	outside := t.TempDir()
+
+	server := newTestServer(t)
+	server.addFile("/mirror/a/evil.txt", []byte("pwned"))
+	c := dialTestServer(t, server)
+
+	local := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(local, "a")))
+
+	m := NewMirror(local, "/mirror", MirrorWithDirection(MirrorDownload))
+	assert.ErrorIs(t, m.Run(c), ErrUnsafePath)
+	assert.NoFileExists(t, filepath.Join(outside, "evil.txt"))
+}
+
+func TestMirrorUniqueDirs(t *testing.T) {
+	server := newTestServer(t)
+	server.addDir("/mirror")
+	server.handle("MLSD", func(sess *testSession, arg string) {
+		switch path.Clean(arg) {
+		case "/mirror":
+			// b is another path to a
+			sess.send([]byte("type=dir;modify=20201213202400;unique=1U2; a\r\n" +
+				"type=dir;modify=20201213202400;unique=1U2; b\r\n"))
+		default:
+			sess.send([]byte("type=file;size=1;modify=20201213202400;unique=1U3; file\r\n"))
+		}
+	})
+	c := dialTestServer(t, server)
+
+	m := NewMirror(t.TempDir(), "/mirror", MirrorWithDirection(MirrorDownload))
+	plan, err := m.Plan(c)
+	require.NoError(t, err)
+	assert.Len(t, plan.Actions, 2)
+	assert.Contains(t, [][]string{
+		{"create local a", "create local a/file"},
+		{"create local b", "create local b/file"},
+	}, actionStrings(plan))
+}
+
+func TestMirrorBoth(t *testing.T) {
+	server := newTestMirror(t)
+	c := dialTestServer(t, server)
+	local := writeLocalTree(t)
+
+	// The local file is more recent than the remote one
+	later := testModTime.Add(time.Hour)
+	require.NoError(t, os.WriteFile(filepath.Join(local, "sub", "b.txt"), []byte("new"), 0o644))
+	require.NoError(t, os.Chtimes(filepath.Join(local, "sub", "b.txt"), later, later))
+
+	m := NewMirror(local, "/mirror", MirrorWithDirection(MirrorBoth), MirrorWithDelete(true))
+	plan, err := m.Plan(c)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"create remote a.txt",
+		"create remote empty",
+		"create local old",
+		"create local old/file",
+		"update remote sub/b.txt",
+	}, actionStrings(plan), "sub/c.log is a conflict and nothing is deleted")
+
+	require.NoError(t, m.Execute(c, plan))
+	assert.Equal(t, "new", string(server.file("/mirror/sub/b.txt")))
+	assert.Equal(t, "changed", string(server.file("/mirror/sub/c.log")))
+	assertLocalFile(t, filepath.Join(local, "old", "file"), "old")
+}
+
+func TestMirrorChecksum(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/mirror/a.txt", []byte("b"))
+	c := dialTestServer(t, server)
+	local := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(local, "a.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.Chtimes(filepath.Join(local, "a.txt"), testModTime, testModTime))
+
+	plan, err := NewMirror(local, "/mirror").Plan(c)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Actions, "same size and time")
+
+	plan, err = NewMirror(local, "/mirror", MirrorWithComparison(MirrorCompareChecksum)).Plan(c)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update remote a.txt"}, actionStrings(plan))
+}
+
+func TestMirrorJournal(t *testing.T) {
+	server := newTestServer(t)
+	c := dialTestServer(t, server)
+	journal := filepath.Join(t.TempDir(), "journal")
+	m := NewMirror(writeLocalTree(t), "/mirror", MirrorWithJournal(journal))
+
+	// The second upload fails
+	var mu sync.Mutex
+	var stored []string
+	server.handle("STOR", func(sess *testSession, arg string) {
+		mu.Lock()
+		stored = append(stored, arg)
+		n := len(stored)
+		mu.Unlock()
+
+		if n == 2 {
+			sess.closeData()
+			sess.reply("452 Insufficient storage")
+			return
+		}
+		sess.builtin("STOR", arg)
+	})
+
+	plan, err := m.Plan(c)
+	require.NoError(t, err)
+	err = m.Execute(c, plan)
+	assert.ErrorIs(t, err, ErrTransient)
+	assert.FileExists(t, journal)
+	assert.NotNil(t, server.lookup("/mirror/a.txt"))
+
+	require.NoError(t, m.Resume(c))
+	assert.NoFileExists(t, journal)
+	assert.Equal(t, "bb", string(server.file("/mirror/sub/b.txt")))
+	assert.Equal(t, "ccc", string(server.file("/mirror/sub/c.log")))
+	assert.Equal(t, []string{"/mirror/a.txt.part", "/mirror/sub/b.txt.part", "/mirror/sub/b.txt.part", "/mirror/sub/c.log.part"}, stored,
+		"the completed actions are not done again")
+
+	assert.ErrorIs(t, m.Resume(c), os.ErrNotExist)
+}
@@ -0,0 +1,152 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// minSegmentSize is the minimum size of a segment of a parallel download
+const minSegmentSize = 1 << 20
+
+// segment is a range of a file downloaded on its own connection
+type segment struct {
+	offset int64 // next byte to download
+	end    int64 // end of the segment, exclusive
+}
+
+// DownloadParallel downloads the remote file into w, splitting it into
+// segments which are downloaded concurrently on several connections of the
+// pool. It returns the size of the file.
+//
+// The number of segments is the maximum number of connections of the pool,
+// unless set with TransferWithSegments. A segment which fails is retried from
+// where it stopped, as configured with TransferWithRetries. The progress of
+// the whole download is reported to the function set with
+// TransferWithProgress.
+//
+// The server must support the SIZE and REST commands.
+func (p *Pool) DownloadParallel(ctx context.Context, remote string, w io.WriterAt, options ...TransferOption) (int64, error) {
+	to := newTransferOptions(options)
+
+	size, err := p.FileSize(remote)
+	if err != nil {
+		return 0, err
+	}
+
+	n := to.segments
+	if n <= 0 {
+		n = p.options.maxConns
+	}
+	if maxSegments := (size + minSegmentSize - 1) / minSegmentSize; int64(n) > maxSegments {
+		n = int(maxSegments)
+	}
+
+	progress := newProgressTracker(remote, DirectionDownload, 0, size, to.progress)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		seg := &segment{
+			offset: size * int64(i) / int64(n),
+			end:    size * int64(i+1) / int64(n),
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if errs[i] = p.downloadSegment(ctx, remote, w, seg, size, to, progress); errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	progress.finish()
+
+	if err := firstError(errs); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// firstError returns the first failure of concurrent tasks rather than the
+// cancellations it caused, or nil if all of them succeeded.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadSegment downloads a segment, retrying from where it stopped
+func (p *Pool) downloadSegment(ctx context.Context, remote string, w io.WriterAt, seg *segment, size int64, to *transferOptions, progress *progressTracker) error {
+	for attempt := 0; ; attempt++ {
+		err := p.Do(ctx, func(c *ServerConn) error {
+			defer c.overrideRateLimit(to.rateLimiter)()
+			return c.retrSegment(ctx, remote, w, seg, size, progress)
+		})
+		if err == nil || ctx.Err() != nil || attempt >= to.retries || isPermanentError(err) {
+			return err
+		}
+	}
+}
+
+// retrSegment downloads a segment on a connection, updating seg.offset with
+// the progress of the transfer.
+func (c *ServerConn) retrSegment(ctx context.Context, remote string, w io.WriterAt, seg *segment, size int64, progress *progressTracker) error {
+	if seg.offset >= seg.end {
+		return nil
+	}
+
+	r, err := c.RetrFromContext(ctx, remote, uint64(seg.offset))
+	if err != nil {
+		return err
+	}
+
+	dst := &segmentWriter{w: w, seg: seg, progress: progress}
+	_, err = io.CopyN(dst, r, seg.end-seg.offset)
+
+	var errClose error
+	if seg.end < size {
+		// Stop the transfer at the end of the segment
+		errClose = r.Abort()
+	} else {
+		errClose = r.Close()
+	}
+
+	return errors.Join(err, errClose)
+}
+
+// segmentWriter writes the data of a segment at its offset
+type segmentWriter struct {
+	w        io.WriterAt
+	seg      *segment
+	progress *progressTracker
+}
+
+func (sw *segmentWriter) Write(buf []byte) (int, error) {
+	n, err := sw.w.WriteAt(buf, sw.seg.offset)
+	sw.seg.offset += int64(n)
+	sw.progress.add(int64(n))
+	return n, err
+}
+
+// isPermanentError reports whether err is a permanent negative reply of the
+// server, which retrying would not fix.
+func isPermanentError(err error) bool {
+	var ftpErr *Error
+	return errors.As(err, &ftpErr) && ftpErr.Code >= 500
+}
@@ -0,0 +1,145 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadParallel(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file", bigTestData)
+
+	var mu sync.Mutex
+	var offsets []int64
+	server.handle("REST", func(sess *testSession, arg string) {
+		sess.builtin("REST", arg)
+		mu.Lock()
+		offsets = append(offsets, sess.rest)
+		mu.Unlock()
+	})
+
+	p := NewPool(server.Addr(), "anonymous", "anonymous")
+	defer p.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	var last Progress
+	size, err := p.DownloadParallel(context.Background(), "/file", f, TransferWithProgress(func(p Progress) {
+		assert.GreaterOrEqual(p.Transferred, last.Transferred)
+		last = p
+	}))
+	require.NoError(t, err)
+	assert.Equal(int64(len(bigTestData)), size)
+
+	buf, err := os.ReadFile(f.Name())
+	assert.NoError(err)
+	assert.True(bytes.Equal(bigTestData, buf))
+
+	assert.Equal(Progress{Path: "/file", Transferred: size, Total: size, Elapsed: last.Elapsed}, last)
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	quarter := int64(len(bigTestData) / 4)
+	assert.Equal([]int64{quarter, 2 * quarter, 3 * quarter}, offsets)
+	assert.LessOrEqual(server.connCount(), DefaultPoolMaxConns)
+}
+
+func TestDownloadParallelSegmentsComplete(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", bigTestData)
+
+	// Send only the requested segment, completing the transfer before ABOR
+	segmentSize := int64(len(bigTestData) / 4)
+	server.handle("RETR", func(sess *testSession, arg string) {
+		rest := sess.rest
+		sess.rest = 0
+		end := rest + segmentSize
+		if end > int64(len(bigTestData)) {
+			end = int64(len(bigTestData))
+		}
+		sess.send(server.file(sess.resolve(arg))[rest:end])
+		<-sess.transfer.done
+	})
+
+	// The segments are downloaded one after the other on the same connection
+	p := NewPool(server.Addr(), "anonymous", "anonymous", PoolWithMaxConns(1))
+	defer p.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	size, err := p.DownloadParallel(context.Background(), "/file", f, TransferWithSegments(4))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(bigTestData)), size)
+
+	buf, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(bigTestData, buf))
+
+	// No reply is left behind on the connection returned to the pool
+	size, err = p.FileSize("/file")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(bigTestData)), size)
+	assert.Equal(t, 1, server.connCount())
+}
+
+func TestDownloadParallelRetry(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file", bigTestData)
+	server.handle("RETR", failOnce("RETR", func(sess *testSession, arg string) {
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+
+		sess.reply("150 Opening data connection")
+		_, _ = conn.Write(bigTestData[sess.rest : sess.rest+1000])
+		sess.rest = 0
+
+		// Reset the data connection in the middle of the segment
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+		sess.reply("426 Transfer aborted")
+	}))
+
+	p := NewPool(server.Addr(), "anonymous", "anonymous")
+	defer p.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = p.DownloadParallel(context.Background(), "/file", f, TransferWithSegments(2))
+	require.NoError(t, err)
+
+	buf, err := os.ReadFile(f.Name())
+	assert.NoError(err)
+	assert.True(bytes.Equal(bigTestData, buf))
+}
+
+func TestDownloadParallelNotFound(t *testing.T) {
+	server := newTestServer(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous")
+	defer p.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = p.DownloadParallel(context.Background(), "/missing", f)
+	assert.Error(t, err)
+}
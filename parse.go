@@ -1,24 +1,157 @@
 package ftp
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-var errUnsupportedListLine = errors.New("unsupported LIST line")
+// ErrUnsupportedListLine is returned by a ListParser for the lines in a format
+// it does not support, so that the next parser is tried.
+var ErrUnsupportedListLine = errors.New("ftp: unsupported LIST line")
+
 var errUnsupportedListDate = errors.New("unsupported LIST date")
 var errUnknownListEntryType = errors.New("unknown entry type")
 
-type parseFunc func(string, time.Time, *time.Location) (*Entry, error)
+// ListParser parses the lines of the response to the LIST command.
+//
+// ParseListLine returns the entry described by the line, given the current
+// time to complete the dates without a year and the location of the times of
+// the server. It returns an error wrapping ErrUnsupportedListLine if the line
+// is not in its format, any other error meaning the line is in its format but
+// is invalid.
+type ListParser interface {
+	ParseListLine(line string, now time.Time, loc *time.Location) (*Entry, error)
+}
+
+// ListParserFunc is an adapter to use a function as a ListParser.
+type ListParserFunc func(line string, now time.Time, loc *time.Location) (*Entry, error)
+
+// ParseListLine calls f(line, now, loc).
+func (f ListParserFunc) ParseListLine(line string, now time.Time, loc *time.Location) (*Entry, error) {
+	return f(line, now, loc)
+}
+
+// listLineParsers are the built-in parsers, in the order they are tried
+var listLineParsers = []ListParser{
+	ListParserFunc(parseRFC3659ListLine),
+	ListParserFunc(parseEPLFListLine),
+	// Before the ls parser, which claims the lines whose first field has 10
+	// characters
+	ListParserFunc(parseVMSListLine),
+	ListParserFunc(parseOS400ListLine),
+	ListParserFunc(parseLsListLine),
+	ListParserFunc(parseDirListLine),
+	ListParserFunc(parseHostedFTPLine),
+	ListParserFunc(parseMVSListLine),
+	ListParserFunc(parseMVSMemberListLine),
+	ListParserFunc(parseTandemListLine),
+	ListParserFunc(parseNetWareListLine),
+}
+
+// registeredListParsers are the parsers added by RegisterListParser
+var (
+	registeredListParsersMu sync.RWMutex
+	registeredListParsers   []ListParser
+)
+
+// RegisterListParser adds a parser tried by all the connections which do not
+// set their parsers with DialWithListParsers. The registered parsers are
+// tried in the order of their registration, before the built-in ones.
+func RegisterListParser(p ListParser) {
+	registeredListParsersMu.Lock()
+	defer registeredListParsersMu.Unlock()
+	registeredListParsers = append(registeredListParsers, p)
+}
+
+// DefaultListParsers returns the parsers tried by default: the registered
+// parsers followed by the built-in ones. The returned slice may be modified,
+// for example to add, reorder or replace parsers given to DialWithListParsers.
+func DefaultListParsers() []ListParser {
+	registeredListParsersMu.RLock()
+	defer registeredListParsersMu.RUnlock()
+
+	parsers := make([]ListParser, 0, len(registeredListParsers)+len(listLineParsers))
+	parsers = append(parsers, registeredListParsers...)
+	return append(parsers, listLineParsers...)
+}
+
+// ListResult is a parsed listing.
+type ListResult struct {
+	Entries []*Entry
+	// Skipped are the lines which could not be parsed, except the summary
+	// lines such as "total 42".
+	Skipped []SkippedLine
+}
+
+// SkippedLine is a line of a listing which could not be parsed.
+type SkippedLine struct {
+	Line string
+	Err  error
+}
+
+// ParseListing parses a listing returned by the LIST command, for example
+// captured from a server, with the given parsers or DefaultListParsers if none
+// is given. The dates without a year are completed with the year of now, and
+// the times are in the given location.
+func ParseListing(r io.Reader, now time.Time, loc *time.Location, parsers ...ListParser) (*ListResult, error) {
+	if len(parsers) == 0 {
+		parsers = DefaultListParsers()
+	}
+	return parseListing(r, parsers, now, loc)
+}
+
+// parseListing parses each line of r with the parsers
+func parseListing(r io.Reader, parsers []ListParser, now time.Time, loc *time.Location) (*ListResult, error) {
+	result := &ListResult{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		entry, err := parseLine(parsers, line, now, loc)
+		switch {
+		case err == nil:
+			result.Entries = append(result.Entries, entry)
+		case !isSummaryListLine(line):
+			result.Skipped = append(result.Skipped, SkippedLine{Line: line, Err: err})
+		}
+	}
+
+	return result, scanner.Err()
+}
 
-var listLineParsers = []parseFunc{
-	parseRFC3659ListLine,
-	parseLsListLine,
-	parseDirListLine,
-	parseHostedFTPLine,
+// isSummaryListLine reports whether line is a blank line, a header or a
+// summary of a listing, which do not describe an entry.
+func isSummaryListLine(line string) bool {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 0:
+		return true
+	case len(fields) == 2 && fields[0] == "total" && isNumber(fields[1]):
+		// ls
+		return true
+	case len(fields) > 2 && fields[0] == "Volume" && fields[1] == "Unit":
+		// Datasets of z/OS
+		return true
+	case len(fields) > 2 && fields[0] == "Name" && fields[1] == "VV.MM":
+		// Members of a partitioned dataset of z/OS
+		return true
+	case len(fields) > 2 && fields[0] == "File" && fields[1] == "Code":
+		// Tandem
+		return true
+	case len(fields) == 2 && fields[0] == "Directory":
+		// OpenVMS
+		return true
+	case strings.HasPrefix(line, "Total of ") || strings.HasPrefix(line, "Grand total of "):
+		// OpenVMS
+		return true
+	}
+	return false
 }
 
 var dirTimeFormats = []string{
@@ -38,25 +171,30 @@ func parseNextRFC3659ListLine(line string, loc *time.Location, e *Entry) (*Entry
 	iWhitespace := strings.Index(line, " ")
 
 	if iSemicolon < 0 || iSemicolon > iWhitespace {
-		return nil, errUnsupportedListLine
+		return nil, ErrUnsupportedListLine
 	}
 
 	name := line[iWhitespace+1:]
 	if e.Name == "" {
 		e.Name = name
+		e.Raw = line
+		e.Facts = make(map[string]string)
 	} else if e.Name != name {
 		// All lines must have the same name
-		return nil, errUnsupportedListLine
+		return nil, ErrUnsupportedListLine
+	} else {
+		e.Raw += "\n" + line
 	}
 
 	for _, field := range strings.Split(line[:iWhitespace-1], ";") {
 		i := strings.Index(field, "=")
 		if i < 1 {
-			return nil, errUnsupportedListLine
+			return nil, ErrUnsupportedListLine
 		}
 
 		key := strings.ToLower(field[:i])
 		value := field[i+1:]
+		e.Facts[key] = value
 
 		switch key {
 		case "modify":
@@ -76,6 +214,22 @@ func parseNextRFC3659ListLine(line string, loc *time.Location, e *Entry) (*Entry
 			if err := e.setSize(value); err != nil {
 				return nil, err
 			}
+		case "unix.mode":
+			if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+				e.Perm = unixPerm(mode)
+			}
+		case "unix.owner", "unix.ownername":
+			e.Owner = value
+		case "unix.uid":
+			if e.Owner == "" {
+				e.Owner = value
+			}
+		case "unix.group", "unix.groupname":
+			e.Group = value
+		case "unix.gid":
+			if e.Group == "" {
+				e.Group = value
+			}
 		}
 	}
 	return e, nil
@@ -89,20 +243,21 @@ func parseLsListLine(line string, now time.Time, loc *time.Location) (*Entry, er
 	// - or 10 bytes with an additional '+' character for indicating ACLs?
 	// If not, return.
 	if i := strings.IndexByte(line, ' '); i != 10 && (i != 11 || line[10] != '+') {
-		return nil, errUnsupportedListLine
+		return nil, ErrUnsupportedListLine
 	}
 
 	scanner := newScanner(line)
 	fields := scanner.NextFields(6)
 
 	if len(fields) < 6 {
-		return nil, errUnsupportedListLine
+		return nil, ErrUnsupportedListLine
 	}
 
 	if fields[1] == "folder" && fields[2] == "0" {
 		e := &Entry{
 			Type: EntryTypeFolder,
 			Name: scanner.Remaining(),
+			Perm: parseLsPerm(fields[0]),
 		}
 		if err := e.setTime(fields[3:6], now, loc); err != nil {
 			return nil, err
@@ -116,10 +271,11 @@ func parseLsListLine(line string, now time.Time, loc *time.Location) (*Entry, er
 		e := &Entry{
 			Type: EntryTypeFile,
 			Name: scanner.Remaining(),
+			Perm: parseLsPerm(fields[0]),
 		}
 
 		if err := e.setSize(fields[2]); err != nil {
-			return nil, errUnsupportedListLine
+			return nil, ErrUnsupportedListLine
 		}
 		if err := e.setTime(fields[4:7], now, loc); err != nil {
 			return nil, err
@@ -131,12 +287,17 @@ func parseLsListLine(line string, now time.Time, loc *time.Location) (*Entry, er
 	// Read two more fields
 	fields = append(fields, scanner.NextFields(2)...)
 	if len(fields) < 8 {
-		return nil, errUnsupportedListLine
+		return nil, ErrUnsupportedListLine
 	}
 
 	e := &Entry{
-		Name: scanner.Remaining(),
+		Name:  scanner.Remaining(),
+		Perm:  parseLsPerm(fields[0]),
+		Owner: fields[2],
+		Group: fields[3],
 	}
+	e.Links, _ = strconv.ParseUint(fields[1], 10, 64)
+
 	switch fields[0][0] {
 	case '-':
 		e.Type = EntryTypeFile
@@ -182,7 +343,7 @@ func parseDirListLine(line string, now time.Time, loc *time.Location) (*Entry, e
 	}
 	if err != nil {
 		// None of the time formats worked.
-		return nil, errUnsupportedListLine
+		return nil, ErrUnsupportedListLine
 	}
 
 	line = strings.TrimLeft(line, " ")
@@ -192,11 +353,11 @@ func parseDirListLine(line string, now time.Time, loc *time.Location) (*Entry, e
 	} else {
 		space := strings.Index(line, " ")
 		if space == -1 {
-			return nil, errUnsupportedListLine
+			return nil, ErrUnsupportedListLine
 		}
 		e.Size, err = strconv.ParseUint(line[:space], 10, 64)
 		if err != nil {
-			return nil, errUnsupportedListLine
+			return nil, ErrUnsupportedListLine
 		}
 		e.Type = EntryTypeFile
 		line = line[space:]
@@ -213,30 +374,311 @@ func parseDirListLine(line string, now time.Time, loc *time.Location) (*Entry, e
 func parseHostedFTPLine(line string, now time.Time, loc *time.Location) (*Entry, error) {
 	// Has the first field a length of 10 bytes?
 	if strings.IndexByte(line, ' ') != 10 {
-		return nil, errUnsupportedListLine
+		return nil, ErrUnsupportedListLine
 	}
 
 	scanner := newScanner(line)
 	fields := scanner.NextFields(2)
 
 	if len(fields) < 2 || fields[1] != "0" {
-		return nil, errUnsupportedListLine
+		return nil, ErrUnsupportedListLine
 	}
 
 	// Set link count to 1 and attempt to parse as Unix.
-	return parseLsListLine(fields[0]+" 1 "+scanner.Remaining(), now, loc)
+	e, err := parseLsListLine(fields[0]+" 1 "+scanner.Remaining(), now, loc)
+	if e != nil {
+		e.Links = 0
+	}
+	return e, err
+}
+
+// parseEPLFListLine parses a directory line in the Easily Parsed LIST Format
+// described in https://cr.yp.to/ftp/list/eplf.html
+// +i8388621.44468,m839956783,r,s10376,\tRFCEPLF
+func parseEPLFListLine(line string, _ time.Time, loc *time.Location) (*Entry, error) {
+	if !strings.HasPrefix(line, "+") {
+		return nil, ErrUnsupportedListLine
+	}
+
+	facts, name, ok := strings.Cut(line[1:], "\t")
+	if !ok || name == "" {
+		return nil, ErrUnsupportedListLine
+	}
+
+	e := &Entry{Name: name}
+	var retrievable bool
+	for _, fact := range strings.Split(facts, ",") {
+		if fact == "" {
+			continue
+		}
+
+		switch fact[0] {
+		case '/':
+			e.Type = EntryTypeFolder
+		case 'r':
+			retrievable = true
+		case 's':
+			if err := e.setSize(fact[1:]); err != nil {
+				return nil, ErrUnsupportedListLine
+			}
+		case 'u':
+			if mode, err := strconv.ParseUint(strings.TrimPrefix(fact, "up"), 8, 32); err == nil {
+				e.Perm = unixPerm(mode)
+			}
+		case 'm':
+			sec, err := strconv.ParseInt(fact[1:], 10, 64)
+			if err != nil {
+				return nil, errUnsupportedListDate
+			}
+			e.Time = time.Unix(sec, 0).In(loc)
+		}
+	}
+
+	if e.Type != EntryTypeFolder && !retrievable {
+		return nil, errUnknownListEntryType
+	}
+
+	return e, nil
+}
+
+// parseVMSListLine parses a directory line of OpenVMS, whose names have a
+// version suffix, and whose sizes are in blocks of 512 bytes.
+// CORE.DIR;1          1/3          5-NOV-1992 16:53:30  [SYSTEM]    (RWED,RWED,RE,RE)
+func parseVMSListLine(line string, _ time.Time, loc *time.Location) (*Entry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, ErrUnsupportedListLine
+	}
+
+	name, version, ok := strings.Cut(fields[0], ";")
+	if !ok || name == "" || !isNumber(version) {
+		return nil, ErrUnsupportedListLine
+	}
+
+	used, _, _ := strings.Cut(fields[1], "/")
+	blocks, err := strconv.ParseUint(used, 10, 64)
+	if err != nil {
+		return nil, ErrUnsupportedListLine
+	}
+
+	e := &Entry{Name: name, Type: EntryTypeFile}
+	if ext := len(name) - len(".DIR"); ext > 0 && strings.EqualFold(name[ext:], ".DIR") {
+		e.Name = name[:ext]
+		e.Type = EntryTypeFolder
+	} else {
+		e.Size = blocks * 512
+	}
+
+	// Ignore the hundredths of seconds
+	clock, _, _ := strings.Cut(fields[3], ".")
+	e.Time, err = parseTime(fields[2]+" "+clock, loc, "2-Jan-2006 15:04:05", "2-Jan-2006 15:04")
+	if err != nil {
+		return nil, errUnsupportedListDate
+	}
+
+	for _, field := range fields[4:] {
+		switch {
+		case strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]"):
+			// [GROUP,OWNER] or [OWNER]
+			uic := field[1 : len(field)-1]
+			if group, owner, ok := strings.Cut(uic, ","); ok {
+				e.Group, e.Owner = group, owner
+			} else {
+				e.Owner = uic
+			}
+		case strings.HasPrefix(field, "(") && strings.HasSuffix(field, ")"):
+			e.Perm = parseVMSPerm(field[1 : len(field)-1])
+		}
+	}
+
+	return e, nil
+}
+
+// parseOS400ListLine parses a directory line of the IFS of IBM OS/400.
+// QSYS            77824 02/23/00 15:09:55 *DIR       QSYS/
+// QPGMR                                   *MEM       QGPL/QCLSRC.FILE/ABC.MBR
+func parseOS400ListLine(line string, _ time.Time, loc *time.Location) (*Entry, error) {
+	scanner := newScanner(line)
+	owner := scanner.Next()
+	if owner == "" {
+		return nil, ErrUnsupportedListLine
+	}
+
+	e := &Entry{Owner: owner}
+	objectType := scanner.Next()
+	if !strings.HasPrefix(objectType, "*") {
+		// The object has a size and a modification time
+		if err := e.setSize(objectType); err != nil {
+			return nil, ErrUnsupportedListLine
+		}
+
+		date, clock := scanner.Next(), scanner.Next()
+		var err error
+		e.Time, err = parseTime(date+" "+clock, loc, "01/02/06 15:04:05", "02.01.06 15:04:05")
+		if err != nil {
+			return nil, ErrUnsupportedListLine
+		}
+
+		objectType = scanner.Next()
+	}
+
+	if len(objectType) < 2 || objectType[0] != '*' || strings.ToUpper(objectType) != objectType {
+		return nil, ErrUnsupportedListLine
+	}
+
+	switch objectType {
+	case "*DIR", "*FLR", "*LIB":
+		e.Type = EntryTypeFolder
+		e.Size = 0
+	default:
+		e.Type = EntryTypeFile
+	}
+
+	e.Name = strings.TrimSuffix(strings.TrimLeft(scanner.Remaining(), " "), "/")
+	if e.Name == "" {
+		return nil, ErrUnsupportedListLine
+	}
+
+	return e, nil
+}
+
+// parseMVSListLine parses a line of the list of the datasets of IBM z/OS.
+// The partitioned datasets, whose dataset organization is PO, are folders.
+// Volume Unit    Referred Ext Used Recfm Lrecl BlkSz Dsorg Dsname
+// WYOSPT 3420   2003/03/21  1  200  FB      80  8800  PS  DATASET.NAME
+// Migrated                                                MIGRATED.NAME
+func parseMVSListLine(line string, _ time.Time, loc *time.Location) (*Entry, error) {
+	fields := strings.Fields(line)
+
+	switch {
+	case len(fields) == 2 && fields[0] == "Migrated":
+		return &Entry{Name: fields[1], Type: EntryTypeFile}, nil
+	case len(fields) == 3 && fields[0] == "Pseudo" && fields[1] == "Directory":
+		return &Entry{Name: fields[2], Type: EntryTypeFolder}, nil
+	case len(fields) != 10:
+		return nil, ErrUnsupportedListLine
+	}
+
+	e := &Entry{Name: fields[9], Type: EntryTypeFile}
+	if fields[2] != "**NONE**" {
+		var err error
+		e.Time, err = parseTime(fields[2], loc, "2006/01/02", "06/01/02")
+		if err != nil {
+			return nil, ErrUnsupportedListLine
+		}
+	}
+
+	if dsorg := fields[8]; dsorg == "PO" || dsorg == "PO-E" {
+		e.Type = EntryTypeFolder
+	}
+
+	return e, nil
+}
+
+// parseMVSMemberListLine parses a line of the list of the members of a
+// partitioned dataset of IBM z/OS. The size is a number of records rather than
+// of bytes, so it is not reported.
+//
+//	Name     VV.MM   Created       Changed      Size  Init   Mod   Id
+//
+// MEMBER    01.01 2002/10/17 2002/10/17 10:46    11    11     0 USERID
+func parseMVSMemberListLine(line string, _ time.Time, loc *time.Location) (*Entry, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 9 {
+		return nil, ErrUnsupportedListLine
+	}
+
+	if version := fields[1]; len(version) != 5 || version[2] != '.' || !isNumber(version[:2]) || !isNumber(version[3:]) {
+		return nil, ErrUnsupportedListLine
+	}
+
+	changed, err := parseTime(fields[3]+" "+fields[4], loc, "2006/01/02 15:04", "06/01/02 15:04")
+	if err != nil {
+		return nil, ErrUnsupportedListLine
+	}
+
+	return &Entry{Name: fields[0], Type: EntryTypeFile, Time: changed, Owner: fields[8]}, nil
+}
+
+// parseTandemListLine parses a directory line of HP NonStop Tandem/Guardian,
+// whose subvolumes only contain files.
+// File         Code             EOF  Last Modification    Owner  RWEP
+// ALTERNAT     101              43 18-Jan-01 14:22:16 255,255 "oooo"
+func parseTandemListLine(line string, _ time.Time, loc *time.Location) (*Entry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 7 || len(fields) > 8 || !isNumber(fields[1]) {
+		return nil, ErrUnsupportedListLine
+	}
+
+	// The owner may be written "255, 255"
+	rwep := fields[len(fields)-1]
+	owner := strings.Join(fields[5:len(fields)-1], "")
+	if len(rwep) < 2 || rwep[0] != '"' || rwep[len(rwep)-1] != '"' || !strings.Contains(owner, ",") {
+		return nil, ErrUnsupportedListLine
+	}
+
+	e := &Entry{Name: fields[0], Type: EntryTypeFile}
+	e.Group, e.Owner, _ = strings.Cut(owner, ",")
+	if err := e.setSize(fields[2]); err != nil {
+		return nil, ErrUnsupportedListLine
+	}
+
+	var err error
+	e.Time, err = parseTime(fields[3]+" "+fields[4], loc, "2-Jan-06 15:04:05")
+	if err != nil {
+		return nil, errUnsupportedListDate
+	}
+
+	return e, nil
+}
+
+// parseNetWareListLine parses a directory line of Novell NetWare.
+// d [R----F--] supervisor            512       Jan 16 18:53 login
+func parseNetWareListLine(line string, now time.Time, loc *time.Location) (*Entry, error) {
+	scanner := newScanner(line)
+	fields := scanner.NextFields(7)
+	if len(fields) < 7 || len(fields[0]) != 1 || !strings.HasPrefix(fields[1], "[") || !strings.HasSuffix(fields[1], "]") {
+		return nil, ErrUnsupportedListLine
+	}
+
+	e := &Entry{Name: scanner.Remaining(), Owner: fields[2]}
+	switch fields[0] {
+	case "d":
+		e.Type = EntryTypeFolder
+	case "-":
+		e.Type = EntryTypeFile
+		if err := e.setSize(fields[3]); err != nil {
+			return nil, ErrUnsupportedListLine
+		}
+	default:
+		return nil, errUnknownListEntryType
+	}
+
+	if err := e.setTime(fields[4:7], now, loc); err != nil {
+		return nil, err
+	}
+
+	return e, nil
 }
 
 // parseListLine parses the various non-standard format returned by the LIST
 // FTP command.
 func parseListLine(line string, now time.Time, loc *time.Location) (*Entry, error) {
-	for _, f := range listLineParsers {
-		e, err := f(line, now, loc)
-		if err != errUnsupportedListLine {
+	return parseLine(listLineParsers, line, now, loc)
+}
+
+// parseLine parses line with the first of the parsers supporting it
+func parseLine(parsers []ListParser, line string, now time.Time, loc *time.Location) (*Entry, error) {
+	for _, p := range parsers {
+		e, err := p.ParseListLine(line, now, loc)
+		if !errors.Is(err, ErrUnsupportedListLine) {
+			if e != nil {
+				e.Raw = line
+			}
 			return e, err
 		}
 	}
-	return nil, errUnsupportedListLine
+	return nil, ErrUnsupportedListLine
 }
 
 func (e *Entry) setSize(str string) (err error) {
@@ -275,3 +717,88 @@ func (e *Entry) setTime(fields []string, now time.Time, loc *time.Location) (err
 	}
 	return
 }
+
+// parseLsPerm returns the permissions of a mode string of ls, e.g. drwxr-sr-x
+func parseLsPerm(mode string) fs.FileMode {
+	if len(mode) < 10 {
+		return 0
+	}
+
+	var perm fs.FileMode
+	for i, c := range mode[1:10] {
+		bit := fs.FileMode(1) << (8 - i)
+		switch c {
+		case 'r', 'w', 'x':
+			perm |= bit
+		case 's', 't':
+			perm |= bit | lsSpecialBits[i/3]
+		case 'S', 'T':
+			perm |= lsSpecialBits[i/3]
+		}
+	}
+	return perm
+}
+
+// lsSpecialBits are the bits shown in place of the execute permission of the
+// owner, of the group and of the others.
+var lsSpecialBits = [3]fs.FileMode{fs.ModeSetuid, fs.ModeSetgid, fs.ModeSticky}
+
+// unixPerm returns the permissions of an octal UNIX mode, e.g. 0755
+func unixPerm(mode uint64) fs.FileMode {
+	perm := fs.FileMode(mode & 0o777)
+	for i, bit := range lsSpecialBits {
+		if mode&(0o4000>>i) != 0 {
+			perm |= bit
+		}
+	}
+	return perm
+}
+
+// parseVMSPerm returns the permissions of an OpenVMS protection, e.g.
+// RWED,RWED,RE,RE for the system, the owner, the group and the world. The
+// delete permission has no equivalent.
+func parseVMSPerm(protection string) fs.FileMode {
+	classes := strings.Split(protection, ",")
+	if len(classes) != 4 {
+		return 0
+	}
+
+	var perm fs.FileMode
+	for i, class := range classes[1:] {
+		shift := 6 - 3*i
+		for _, c := range class {
+			switch c {
+			case 'R':
+				perm |= 0o4 << shift
+			case 'W':
+				perm |= 0o2 << shift
+			case 'E':
+				perm |= 0o1 << shift
+			}
+		}
+	}
+	return perm
+}
+
+// parseTime parses value with the first matching layout
+func parseTime(value string, loc *time.Location, layouts ...string) (t time.Time, err error) {
+	for _, layout := range layouts {
+		if t, err = time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+// isNumber reports whether str is a non-empty string of decimal digits
+func isNumber(str string) bool {
+	if str == "" {
+		return false
+	}
+	for i := 0; i < len(str); i++ {
+		if str[i] < '0' || str[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,14 @@
 package ftp
 
 import (
+	"fmt"
+	"io/fs"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -81,6 +84,37 @@ var listTests = []line{
 
 	// Line with ACL persmissions
 	{"-rwxrw-r--+  1 521      101         2080 May 21 10:53 data.csv", "data.csv", 2080, EntryTypeFile, newTime(thisYear, time.May, 21, 10, 53)},
+
+	// EPLF: https://cr.yp.to/ftp/list/eplf.html
+	{"+i8388621.29609,m824255902,/,\tdev", "dev", 0, EntryTypeFolder, newTime(1996, time.February, 13, 23, 58, 22)},
+	{"+i8388621.44468,m825718503,r,s10376,\tRFC EPLF", "RFC EPLF", 10376, EntryTypeFile, newTime(1996, time.March, 1, 22, 15, 3)},
+	{"+r,s280,\tdjb.html", "djb.html", 280, EntryTypeFile, time.Time{}},
+
+	// OpenVMS, sizes in blocks of 512 bytes
+	{"CORE.DIR;1          1/3          5-NOV-1992 16:53:30  [SYSTEM]    (RWED,RWED,RE,RE)", "CORE", 0, EntryTypeFolder, newTime(1992, time.November, 5, 16, 53, 30)},
+	{"README.TXT;12          2/3     12-MAR-2015 10:11:12.34  [GROUP,OWNER]    (RWED,RWED,RE,)", "README.TXT", 1024, EntryTypeFile, newTime(2015, time.March, 12, 10, 11, 12)},
+	{"LOGIN.COM;1       4  28-Jan-2001 09:05 [USER]  (RWED,RWED,,)", "LOGIN.COM", 2048, EntryTypeFile, newTime(2001, time.January, 28, 9, 5)},
+
+	// IBM z/OS datasets and members of partitioned datasets
+	{"WYOSPT 3420   2003/03/21  1  200  FB      80  8800  PS  DATASET.NAME", "DATASET.NAME", 0, EntryTypeFile, newTime(2003, time.March, 21)},
+	{"SMS001 3390   2019/12/11  1   15  FB      80 27920  PO  USER.SOURCE", "USER.SOURCE", 0, EntryTypeFolder, newTime(2019, time.December, 11)},
+	{"Migrated                                                USER.OLD.DATA", "USER.OLD.DATA", 0, EntryTypeFile, time.Time{}},
+	{"Pseudo Directory                                        USER.TEST", "USER.TEST", 0, EntryTypeFolder, time.Time{}},
+	{"MEMBER    01.01 2002/10/17 2002/10/18 10:46    11    11     0 USERID", "MEMBER", 0, EntryTypeFile, newTime(2002, time.October, 18, 10, 46)},
+
+	// IBM OS/400
+	{"QSYS            77824 02/23/00 15:09:55 *DIR       QSYS/", "QSYS", 0, EntryTypeFolder, newTime(2000, time.February, 23, 15, 9, 55)},
+	{"QSYSOPRXYZ       4096 23.02.00 15:09:55 *STMF      file name.txt", "file name.txt", 4096, EntryTypeFile, newTime(2000, time.February, 23, 15, 9, 55)},
+	{"QPGMR                                   *MEM       QGPL/QCLSRC.FILE/ABC.MBR", "QGPL/QCLSRC.FILE/ABC.MBR", 0, EntryTypeFile, time.Time{}},
+
+	// HP NonStop Tandem/Guardian
+	{"ALTERNAT     101              43 18-Jan-01 14:22:16 255,255 \"oooo\"", "ALTERNAT", 43, EntryTypeFile, newTime(2001, time.January, 18, 14, 22, 16)},
+	{"EMSACSTM     101           11264 13-Jul-17 16:11:20 255, 255 \"nunu\"", "EMSACSTM", 11264, EntryTypeFile, newTime(2017, time.July, 13, 16, 11, 20)},
+
+	// Novell NetWare
+	{"d [R----F--] supervisor            512       Jan 16 18:53 login", "login", 0, EntryTypeFolder, newTime(thisYear, time.January, 16, 18, 53)},
+	{"- [R----F--] rhesus             214059       Oct 20 15:27 cx.exe", "cx.exe", 214059, EntryTypeFile, newTime(previousYear, time.October, 20, 15, 27)},
+	{"- [RWCEAFMS] owner                 42       Oct 20  2015 file name", "file name", 42, EntryTypeFile, newTime(2015, time.October, 20)},
 }
 
 var listTestsSymlink = []symlinkLine{
@@ -90,14 +124,18 @@ var listTestsSymlink = []symlinkLine{
 
 // Not supported, we expect a specific error message
 var listTestsFail = []unsupportedLine{
-	{"d [R----F--] supervisor            512       Jan 16 18:53 login", errUnsupportedListLine},
-	{"- [R----F--] rhesus             214059       Oct 20 15:27 cx.exe", errUnsupportedListLine},
 	{"drwxr-xr-x    3 110      1002            3 Dec 02  209 pub", errUnsupportedListDate},
-	{"modify=20150806235817;invalid;UNIX.owner=0; movies", errUnsupportedListLine},
+	{"modify=20150806235817;invalid;UNIX.owner=0; movies", ErrUnsupportedListLine},
 	{"Zrwxrwxrwx   1 root     other          7 Jan 25 00:17 bin -> usr/bin", errUnknownListEntryType},
-	{"total 1", errUnsupportedListLine},
-	{"000000000x ", errUnsupportedListLine}, // see https://github.com/jlaffaye/ftp/issues/97
-	{"", errUnsupportedListLine},
+	{"total 1", ErrUnsupportedListLine},
+	{"000000000x ", ErrUnsupportedListLine}, // see https://github.com/jlaffaye/ftp/issues/97
+	{"", ErrUnsupportedListLine},
+	{"+i8388621.29609,m824255902,\tdevice", errUnknownListEntryType},
+	{"README.TXT;1       2  32-MAR-2015 10:11 [USER]  (RWED,RWED,,)", errUnsupportedListDate},
+	{"Volume Unit    Referred Ext Used Recfm Lrecl BlkSz Dsorg Dsname", ErrUnsupportedListLine},
+	{" Name     VV.MM   Created       Changed      Size  Init   Mod   Id", ErrUnsupportedListLine},
+	{"File         Code             EOF  Last Modification    Owner  RWEP", ErrUnsupportedListLine},
+	{"x [R----F--] supervisor            512       Jan 16 18:53 login", errUnknownListEntryType},
 }
 
 func TestParseValidListLine(t *testing.T) {
@@ -131,6 +169,67 @@ func TestParseSymlinks(t *testing.T) {
 	}
 }
 
+type metadataLine struct {
+	line  string
+	perm  fs.FileMode
+	owner string
+	group string
+	links uint64
+}
+
+var listTestsMetadata = []metadataLine{
+	{"drwxr-xr-x    3 110      1002            3 Dec 02  2009 pub", 0o755, "110", "1002", 3},
+	{"-rwsr-sr-t   1 root     other          7 Jan 25 00:17 su", 0o755 | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky, "root", "other", 1},
+	{"-rwSr-Sr-T   1 root     other          7 Jan 25 00:17 su", 0o644 | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky, "root", "other", 1},
+	{"-rwxrw-r--+  1 521      101         2080 May 21 10:53 data.csv", 0o764, "521", "101", 1},
+	{"drwxrwxrwx               folder        0 Aug 11 20:32 P0RN", 0o777, "", "", 0},
+	{"-r--------   0 user group     65222236 Feb 24 00:39 RegularFile", 0o400, "user", "group", 0},
+	{"modify=20150813175250;perm=adfr;size=951;type=file;unique=119FBB87UE;UNIX.group=0;UNIX.mode=04644;UNIX.owner=0; welcome.msg", 0o644 | fs.ModeSetuid, "0", "0", 0},
+	{"modify=20150813175250;type=file;unix.uid=1000;unix.gid=100;unix.ownername=joe;unix.groupname=users; a.txt", 0, "joe", "users", 0},
+	{"+i8388621.44468,m825718503,r,s10376,up640,\tRFCEPLF", 0o640, "", "", 0},
+	{"README.TXT;12          2/3     12-MAR-2015 10:11:12.34  [GROUP,OWNER]    (RWED,RWED,RE,)", 0o750, "OWNER", "GROUP", 0},
+	{"CORE.DIR;1          1/3          5-NOV-1992 16:53:30  [SYSTEM]    (RWED,RWED,RE,RE)", 0o755, "SYSTEM", "", 0},
+	{"QSYS            77824 02/23/00 15:09:55 *DIR       QSYS/", 0, "QSYS", "", 0},
+	{"MEMBER    01.01 2002/10/17 2002/10/18 10:46    11    11     0 USERID", 0, "USERID", "", 0},
+	{"ALTERNAT     101              43 18-Jan-01 14:22:16 255,254 \"oooo\"", 0, "254", "255", 0},
+	{"d [R----F--] supervisor            512       Jan 16 18:53 login", 0, "supervisor", "", 0},
+}
+
+func TestParseMetadata(t *testing.T) {
+	for _, lt := range listTestsMetadata {
+		t.Run(lt.line, func(t *testing.T) {
+			assert := assert.New(t)
+			entry, err := parseListLine(lt.line, now, time.UTC)
+
+			if assert.NoError(err) {
+				assert.Equal(lt.perm, entry.Perm)
+				assert.Equal(lt.owner, entry.Owner)
+				assert.Equal(lt.group, entry.Group)
+				assert.Equal(lt.links, entry.Links)
+				assert.Equal(lt.line, entry.Raw)
+			}
+		})
+	}
+}
+
+func TestParseFacts(t *testing.T) {
+	line := "modify=20150813175250;perm=adfr;size=951;type=file;unique=119FBB87UE;media-type=text/plain; welcome.msg"
+	entry, err := parseListLine(line, now, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"modify":     "20150813175250",
+		"perm":       "adfr",
+		"size":       "951",
+		"type":       "file",
+		"unique":     "119FBB87UE",
+		"media-type": "text/plain",
+	}, entry.Facts)
+
+	entry, err = parseListLine("drwxr-xr-x    3 110      1002            3 Dec 02  2009 pub", now, time.UTC)
+	require.NoError(t, err)
+	assert.Nil(t, entry.Facts)
+}
+
 func TestParseUnsupportedListLine(t *testing.T) {
 	for _, lt := range listTestsFail {
 		t.Run(lt.line, func(t *testing.T) {
@@ -141,6 +240,85 @@ func TestParseUnsupportedListLine(t *testing.T) {
 	}
 }
 
+// pipeListParser parses the lines "name|size" of an imaginary server
+var pipeListParser = ListParserFunc(func(line string, _ time.Time, _ *time.Location) (*Entry, error) {
+	name, size, ok := strings.Cut(line, "|")
+	if !ok {
+		return nil, fmt.Errorf("%w: no pipe", ErrUnsupportedListLine)
+	}
+	e := &Entry{Name: name, Type: EntryTypeFile}
+	return e, e.setSize(size)
+})
+
+func TestParseListing(t *testing.T) {
+	listing := "total 2\r\n" +
+		"drwxr-xr-x    3 110      1002            3 Dec 02  2009 pub\r\n" +
+		"data.csv|2080\r\n"
+
+	result, err := ParseListing(strings.NewReader(listing), now, time.UTC)
+	require.NoError(t, err)
+	require.Len(t, result.Entries, 1)
+	assert.Equal(t, "pub", result.Entries[0].Name)
+	require.Len(t, result.Skipped, 1)
+	assert.Equal(t, "data.csv|2080", result.Skipped[0].Line)
+	assert.ErrorIs(t, result.Skipped[0].Err, ErrUnsupportedListLine)
+
+	result, err = ParseListing(strings.NewReader(listing), now, time.UTC, append(DefaultListParsers(), pipeListParser)...)
+	require.NoError(t, err)
+	require.Len(t, result.Entries, 2)
+	assert.Empty(t, result.Skipped)
+	assert.Equal(t, "pub", result.Entries[0].Name)
+	assert.Equal(t, "data.csv", result.Entries[1].Name)
+	assert.Equal(t, uint64(2080), result.Entries[1].Size)
+}
+
+func TestParseListingSummaryLines(t *testing.T) {
+	listing := "Directory DISK$USER:[FTP]\r\n" +
+		"\r\n" +
+		"README.TXT;1       2  12-MAR-2015 10:11 [USER]  (RWED,RWED,,)\r\n" +
+		"\r\n" +
+		"Total of 1 file, 2/3 blocks.\r\n" +
+		"Volume Unit    Referred Ext Used Recfm Lrecl BlkSz Dsorg Dsname\r\n" +
+		" Name     VV.MM   Created       Changed      Size  Init   Mod   Id\r\n" +
+		"File         Code             EOF  Last Modification    Owner  RWEP\r\n" +
+		"drwxr-xr-x    3 110      1002            3 Dec 02  209 pub\r\n"
+
+	result, err := ParseListing(strings.NewReader(listing), now, time.UTC)
+	require.NoError(t, err)
+	require.Len(t, result.Entries, 1)
+	assert.Equal(t, "README.TXT", result.Entries[0].Name)
+	require.Len(t, result.Skipped, 1)
+	assert.Equal(t, errUnsupportedListDate, result.Skipped[0].Err)
+}
+
+func TestRegisterListParser(t *testing.T) {
+	t.Cleanup(func() {
+		registeredListParsers = nil
+	})
+
+	builtin := len(DefaultListParsers())
+	RegisterListParser(pipeListParser)
+	assert.Len(t, DefaultListParsers(), builtin+1)
+
+	result, err := ParseListing(strings.NewReader("a.txt|1\r\n"), now, time.UTC)
+	require.NoError(t, err)
+	require.Len(t, result.Entries, 1)
+	assert.Equal(t, "a.txt", result.Entries[0].Name)
+}
+
+func TestListWithListParsers(t *testing.T) {
+	server := newTestServer(t)
+	server.handle("LIST", func(sess *testSession, arg string) {
+		sess.send([]byte("a.txt|1\r\n-rw-r--r-- 1 ftp ftp 2 Dec 13  2020 b.txt\r\n"))
+	})
+
+	c := dialTestServer(t, server, DialWithDisabledMLSD(true), DialWithListParsers(pipeListParser))
+	entries, err := c.List("/")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "a.txt", entries[0].Name)
+}
+
 func TestSettime(t *testing.T) {
 	tests := []struct {
 		line     string
@@ -192,3 +370,25 @@ func newTime(year int, month time.Month, day int, hourMinSec ...int) time.Time {
 
 	return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
 }
+
+func TestListWithSkipped(t *testing.T) {
+	server := newTestServer(t)
+	server.handle("LIST", func(sess *testSession, arg string) {
+		sess.send([]byte("total 2\r\n" +
+			"-rw-r--r-- 1 ftp ftp 1 Dec 13  2020 a.txt\r\n" +
+			"-rw-r--r-- 1 ftp ftp 2 2020-12-13 20:24 b.txt\r\n"))
+	})
+
+	c := dialTestServer(t, server, DialWithDisabledMLSD(true))
+	result, err := c.ListWithSkipped("/")
+	require.NoError(t, err)
+	require.Len(t, result.Entries, 1)
+	assert.Equal(t, "a.txt", result.Entries[0].Name)
+	require.Len(t, result.Skipped, 1)
+	assert.Equal(t, "-rw-r--r-- 1 ftp ftp 2 2020-12-13 20:24 b.txt", result.Skipped[0].Line)
+	assert.Error(t, result.Skipped[0].Err)
+
+	entries, err := c.List("/")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,479 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPoolMaxConns is the default maximum number of connections of a Pool.
+	DefaultPoolMaxConns = 4
+
+	// DefaultPoolIdleTimeout is the default duration after which an idle
+	// connection of a Pool is closed.
+	DefaultPoolIdleTimeout = 5 * time.Minute
+)
+
+// ErrPoolClosed is returned when using a Pool after Close has been called.
+var ErrPoolClosed = errors.New("ftp: pool closed")
+
+// Pool is a pool of logged in connections to a FTP server.
+//
+// Unlike ServerConn, a Pool is safe to be called concurrently: each call
+// borrows a connection for its duration, so one Pool can serve many
+// goroutines. The connections are dialed lazily and are checked with NoOp
+// before being reused.
+//
+// The working directory of the connections is the login directory. Functions
+// passed to Do must restore it if they change it.
+type Pool struct {
+	addr     string
+	user     string
+	password string
+	options  *poolOptions
+
+	// sem holds a token for each connection in use or being dialed
+	sem chan struct{}
+
+	mu     sync.Mutex
+	idle   []idleConn // from the least to the most recently used
+	evict  *time.Timer
+	closed bool
+}
+
+// idleConn is a connection waiting in the pool
+type idleConn struct {
+	c     *ServerConn
+	since time.Time
+}
+
+// PoolOption represents an option to create a new Pool with NewPool
+type PoolOption struct {
+	setup func(po *poolOptions)
+}
+
+// poolOptions contains all the options set by PoolOption.setup
+type poolOptions struct {
+	dialOptions []DialOption
+	maxConns    int
+	idleTimeout time.Duration
+}
+
+// NewPool returns a Pool of connections to the specified address which are
+// logged in with the given user and password.
+func NewPool(addr, user, password string, options ...PoolOption) *Pool {
+	po := &poolOptions{
+		maxConns:    DefaultPoolMaxConns,
+		idleTimeout: DefaultPoolIdleTimeout,
+	}
+	for _, option := range options {
+		option.setup(po)
+	}
+
+	if po.maxConns < 1 {
+		po.maxConns = 1
+	}
+
+	return &Pool{
+		addr:     addr,
+		user:     user,
+		password: password,
+		options:  po,
+		sem:      make(chan struct{}, po.maxConns),
+	}
+}
+
+// PoolWithDialOptions returns a PoolOption that configures the options used
+// to dial the connections of the Pool.
+func PoolWithDialOptions(options ...DialOption) PoolOption {
+	return PoolOption{func(po *poolOptions) {
+		po.dialOptions = append(po.dialOptions, options...)
+	}}
+}
+
+// PoolWithMaxConns returns a PoolOption that configures the maximum number of
+// connections, idle or in use, of the Pool.
+func PoolWithMaxConns(maxConns int) PoolOption {
+	return PoolOption{func(po *poolOptions) {
+		po.maxConns = maxConns
+	}}
+}
+
+// PoolWithIdleTimeout returns a PoolOption that configures the duration after
+// which an idle connection is closed, in the background even if the Pool is not
+// used anymore. A zero duration disables the eviction.
+func PoolWithIdleTimeout(timeout time.Duration) PoolOption {
+	return PoolOption{func(po *poolOptions) {
+		po.idleTimeout = timeout
+	}}
+}
+
+// Get borrows a connection from the pool, dialing a new one if no idle
+// connection is available. It blocks until a connection is available or the
+// context is done.
+//
+// The connection must be given back with Put, or with Discard if it is broken.
+func (p *Pool) Get(ctx context.Context) (*ServerConn, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		c, err := p.popIdle()
+		if err != nil {
+			<-p.sem
+			return nil, err
+		}
+		if c == nil {
+			break
+		}
+
+		if err := c.NoOp(); err == nil {
+			return c, nil
+		}
+		_ = c.Quit()
+	}
+
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// popIdle returns the most recently used idle connection, or nil if there is
+// none. Connections idle for too long are closed.
+func (p *Pool) popIdle() (*ServerConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+
+	expired := p.popExpired()
+
+	var c *ServerConn
+	if n := len(p.idle); n > 0 {
+		c = p.idle[n-1].c
+		p.idle = p.idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	for _, e := range expired {
+		_ = e.Quit()
+	}
+
+	return c, nil
+}
+
+// popExpired removes the connections idle for too long from the pool and
+// returns them. It must be called with p.mu held.
+func (p *Pool) popExpired() []*ServerConn {
+	if p.options.idleTimeout <= 0 {
+		return nil
+	}
+
+	var expired []*ServerConn
+	deadline := time.Now().Add(-p.options.idleTimeout)
+	i := 0
+	for i < len(p.idle) && !p.idle[i].since.After(deadline) {
+		expired = append(expired, p.idle[i].c)
+		i++
+	}
+	p.idle = p.idle[i:]
+	return expired
+}
+
+// scheduleEviction arms the timer closing the least recently used idle
+// connection once it expires. It must be called with p.mu held.
+func (p *Pool) scheduleEviction() {
+	if p.options.idleTimeout <= 0 || p.evict != nil || p.closed || len(p.idle) == 0 {
+		return
+	}
+
+	delay := time.Until(p.idle[0].since.Add(p.options.idleTimeout))
+	p.evict = time.AfterFunc(delay, p.evictExpired)
+}
+
+// evictExpired closes the connections idle for too long
+func (p *Pool) evictExpired() {
+	p.mu.Lock()
+	p.evict = nil
+	expired := p.popExpired()
+	p.scheduleEviction()
+	p.mu.Unlock()
+
+	for _, c := range expired {
+		_ = c.Quit()
+	}
+}
+
+func (p *Pool) dial(ctx context.Context) (*ServerConn, error) {
+	options := append([]DialOption{}, p.options.dialOptions...)
+	options = append(options, DialWithContext(ctx))
+
+	c, err := Dial(p.addr, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Login(p.user, p.password); err != nil {
+		_ = c.Quit()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Put gives back a healthy connection obtained with Get.
+func (p *Pool) Put(c *ServerConn) {
+	p.mu.Lock()
+	closed := p.closed
+	if !closed {
+		p.idle = append(p.idle, idleConn{c: c, since: time.Now()})
+		p.scheduleEviction()
+	}
+	p.mu.Unlock()
+
+	if closed {
+		_ = c.Quit()
+	}
+	<-p.sem
+}
+
+// Discard closes a broken connection obtained with Get, freeing its slot in
+// the pool.
+func (p *Pool) Discard(c *ServerConn) {
+	_ = c.Quit()
+	<-p.sem
+}
+
+// release gives back a connection depending on the error of its last use
+func (p *Pool) release(c *ServerConn, err error) {
+	if isConnBroken(err) {
+		p.Discard(c)
+	} else {
+		p.Put(c)
+	}
+}
+
+// isConnBroken reports whether the connection which returned err should not
+// be reused. Only the errors at the protocol level leave it usable.
+func isConnBroken(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var ftpErr *Error
+	if !errors.As(err, &ftpErr) {
+		return true
+	}
+	return ftpErr.Code == StatusNotAvailable
+}
+
+// Close closes the idle connections of the pool. The connections in use are
+// closed when they are given back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	if p.evict != nil {
+		p.evict.Stop()
+		p.evict = nil
+	}
+	p.mu.Unlock()
+
+	var errs []error
+	for _, ic := range idle {
+		if err := ic.c.Quit(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Do borrows a connection for the duration of f.
+// The connection is discarded if f returns an error that may have broken it.
+func (p *Pool) Do(ctx context.Context, f func(c *ServerConn) error) error {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = f(c)
+	p.release(c, err)
+
+	return err
+}
+
+// Retr issues a RETR FTP command on a pooled connection.
+// See ServerConn.Retr.
+//
+// The connection is borrowed until the returned Response is closed.
+func (p *Pool) Retr(path string) (*Response, error) {
+	return p.RetrFrom(path, 0)
+}
+
+// RetrFrom issues a RETR FTP command on a pooled connection.
+// See ServerConn.RetrFrom.
+//
+// The connection is borrowed until the returned Response is closed.
+func (p *Pool) RetrFrom(path string, offset uint64) (*Response, error) {
+	c, err := p.Get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.RetrFrom(path, offset)
+	if err != nil {
+		p.release(c, err)
+		return nil, err
+	}
+
+	r.release = func(err error) {
+		p.release(c, err)
+	}
+
+	return r, nil
+}
+
+// Stor issues a STOR FTP command on a pooled connection.
+// See ServerConn.Stor.
+func (p *Pool) Stor(path string, r io.Reader) error {
+	return p.StorFrom(path, r, 0)
+}
+
+// StorFrom issues a STOR FTP command on a pooled connection.
+// See ServerConn.StorFrom.
+func (p *Pool) StorFrom(path string, r io.Reader, offset uint64) error {
+	return p.Do(context.Background(), func(c *ServerConn) error {
+		return c.StorFrom(path, r, offset)
+	})
+}
+
+// Append issues a APPE FTP command on a pooled connection.
+// See ServerConn.Append.
+func (p *Pool) Append(path string, r io.Reader) error {
+	return p.Do(context.Background(), func(c *ServerConn) error {
+		return c.Append(path, r)
+	})
+}
+
+// List issues a LIST FTP command on a pooled connection.
+// See ServerConn.List.
+func (p *Pool) List(path string) (entries []*Entry, err error) {
+	err = p.Do(context.Background(), func(c *ServerConn) error {
+		entries, err = c.List(path)
+		return err
+	})
+	return entries, err
+}
+
+// ListWithSkipped issues a LIST FTP command on a pooled connection.
+// See ServerConn.ListWithSkipped.
+func (p *Pool) ListWithSkipped(path string) (result *ListResult, err error) {
+	err = p.Do(context.Background(), func(c *ServerConn) error {
+		result, err = c.ListWithSkipped(path)
+		return err
+	})
+	return result, err
+}
+
+// NameList issues an NLST FTP command on a pooled connection.
+// See ServerConn.NameList.
+func (p *Pool) NameList(path string) (entries []string, err error) {
+	err = p.Do(context.Background(), func(c *ServerConn) error {
+		entries, err = c.NameList(path)
+		return err
+	})
+	return entries, err
+}
+
+// GetEntry issues a MLST FTP command on a pooled connection.
+// See ServerConn.GetEntry.
+func (p *Pool) GetEntry(path string) (entry *Entry, err error) {
+	err = p.Do(context.Background(), func(c *ServerConn) error {
+		entry, err = c.GetEntry(path)
+		return err
+	})
+	return entry, err
+}
+
+// FileSize issues a SIZE FTP command on a pooled connection.
+// See ServerConn.FileSize.
+func (p *Pool) FileSize(path string) (size int64, err error) {
+	err = p.Do(context.Background(), func(c *ServerConn) error {
+		size, err = c.FileSize(path)
+		return err
+	})
+	return size, err
+}
+
+// GetTime issues a MDTM FTP command on a pooled connection.
+// See ServerConn.GetTime.
+func (p *Pool) GetTime(path string) (t time.Time, err error) {
+	err = p.Do(context.Background(), func(c *ServerConn) error {
+		t, err = c.GetTime(path)
+		return err
+	})
+	return t, err
+}
+
+// Hash returns the checksum of a file on a pooled connection.
+// See ServerConn.Hash.
+func (p *Pool) Hash(path string, algo HashAlgorithm) (sum []byte, err error) {
+	err = p.Do(context.Background(), func(c *ServerConn) error {
+		sum, err = c.Hash(path, algo)
+		return err
+	})
+	return sum, err
+}
+
+// SetTime issues a MFMT FTP command on a pooled connection.
+// See ServerConn.SetTime.
+func (p *Pool) SetTime(path string, t time.Time) error {
+	return p.Do(context.Background(), func(c *ServerConn) error {
+		return c.SetTime(path, t)
+	})
+}
+
+// Rename renames a file on a pooled connection.
+// See ServerConn.Rename.
+func (p *Pool) Rename(from, to string) error {
+	return p.Do(context.Background(), func(c *ServerConn) error {
+		return c.Rename(from, to)
+	})
+}
+
+// Delete issues a DELE FTP command on a pooled connection.
+// See ServerConn.Delete.
+func (p *Pool) Delete(path string) error {
+	return p.Do(context.Background(), func(c *ServerConn) error {
+		return c.Delete(path)
+	})
+}
+
+// MakeDir issues a MKD FTP command on a pooled connection.
+// See ServerConn.MakeDir.
+func (p *Pool) MakeDir(path string) error {
+	return p.Do(context.Background(), func(c *ServerConn) error {
+		return c.MakeDir(path)
+	})
+}
+
+// RemoveDir issues a RMD FTP command on a pooled connection.
+// See ServerConn.RemoveDir.
+func (p *Pool) RemoveDir(path string) error {
+	return p.Do(context.Background(), func(c *ServerConn) error {
+		return c.RemoveDir(path)
+	})
+}
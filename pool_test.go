@@ -0,0 +1,160 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolReuse(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous")
+	defer p.Close()
+
+	err := p.Stor("/file", bytes.NewBufferString(testData))
+	assert.NoError(err)
+
+	r, err := p.Retr("/file")
+	if assert.NoError(err) {
+		buf, err := io.ReadAll(r)
+		assert.NoError(err)
+		assert.Equal(testData, string(buf))
+		assert.NoError(r.Close())
+	}
+
+	entries, err := p.List("/")
+	if assert.NoError(err) && assert.Len(entries, 1) {
+		assert.Equal("file", entries[0].Name)
+	}
+
+	size, err := p.FileSize("/file")
+	assert.NoError(err)
+	assert.Equal(int64(len(testData)), size)
+
+	// A protocol error does not discard the connection
+	_, err = p.FileSize("/missing")
+	assert.Error(err)
+
+	assert.NoError(p.Delete("/file"))
+	assert.Equal(1, server.connCount())
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	server := newTestServer(t)
+	for i := 0; i < 10; i++ {
+		server.addFile(fmt.Sprintf("/file%d", i), []byte(fmt.Sprintf("content %d", i)))
+	}
+
+	p := NewPool(server.Addr(), "anonymous", "anonymous", PoolWithMaxConns(3))
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			r, err := p.Retr(fmt.Sprintf("/file%d", i))
+			if !assert.NoError(t, err) {
+				return
+			}
+			buf, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("content %d", i), string(buf))
+			assert.NoError(t, r.Close())
+		}(i)
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, server.connCount(), 3)
+}
+
+func TestPoolBrokenConn(t *testing.T) {
+	server := newTestServer(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous")
+	defer p.Close()
+
+	c, err := p.Get(context.Background())
+	require.NoError(t, err)
+
+	// Simulate a connection dropped while idle
+	require.NoError(t, c.netConn.Close())
+	p.Put(c)
+
+	// The health check detects the broken connection
+	assert.NoError(t, p.MakeDir("/dir"))
+	assert.Equal(t, 2, server.connCount())
+
+	// Errors not at the protocol level discard the connection
+	err = p.Do(context.Background(), func(c *ServerConn) error {
+		_ = c.netConn.Close()
+		return c.NoOp()
+	})
+	assert.Error(t, err)
+	assert.NoError(t, p.RemoveDir("/dir"))
+	assert.Equal(t, 3, server.connCount())
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	server := newTestServer(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous", PoolWithIdleTimeout(time.Millisecond))
+	defer p.Close()
+
+	assert.NoError(t, p.MakeDir("/dir"))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, p.RemoveDir("/dir"))
+
+	assert.Equal(t, 2, server.connCount())
+}
+
+func TestPoolIdleEviction(t *testing.T) {
+	server := newTestServer(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous", PoolWithIdleTimeout(20*time.Millisecond))
+	defer p.Close()
+
+	assert.NoError(t, p.MakeDir("/dir"))
+	assert.Equal(t, 1, server.sessionCount())
+
+	// The idle connection is closed without using the pool again
+	assert.Eventually(t, func() bool {
+		return server.sessionCount() == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestPoolMaxConns(t *testing.T) {
+	server := newTestServer(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous", PoolWithMaxConns(1))
+	defer p.Close()
+
+	c, err := p.Get(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	p.Put(c)
+	c, err = p.Get(context.Background())
+	require.NoError(t, err)
+	p.Put(c)
+}
+
+func TestPoolClosed(t *testing.T) {
+	server := newTestServer(t)
+	p := NewPool(server.Addr(), "anonymous", "anonymous")
+
+	assert.NoError(t, p.MakeDir("/dir"))
+	assert.NoError(t, p.Close())
+
+	err := p.MakeDir("/dir2")
+	assert.ErrorIs(t, err, ErrPoolClosed)
+}
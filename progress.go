@@ -0,0 +1,249 @@
+package ftp
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressInterval is the minimum interval between two calls of a
+// ProgressFunc for the same transfer, except for the last call.
+const progressInterval = 100 * time.Millisecond
+
+// Direction is the direction of a transfer
+type Direction int
+
+// Directions of a transfer
+const (
+	DirectionDownload Direction = iota
+	DirectionUpload
+)
+
+// Progress describes the state of a transfer in progress.
+type Progress struct {
+	// Path is the remote path of the transferred file, or of the listed
+	// directory
+	Path string
+
+	Direction Direction
+
+	// Transferred is the number of bytes transferred so far, including the
+	// bytes transferred before the transfer was resumed.
+	Transferred int64
+
+	// Total is the size of the file, or -1 if it is unknown
+	Total int64
+
+	// Elapsed is the time elapsed since the start of the transfer
+	Elapsed time.Duration
+
+	offset int64 // bytes transferred before the transfer was resumed
+}
+
+// Rate returns the average speed of the transfer in bytes per second.
+// The bytes transferred before the transfer was resumed are not counted.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Transferred-p.offset) / p.Elapsed.Seconds()
+}
+
+// ETA returns the estimated time remaining until the end of the transfer,
+// based on its average speed. It returns -1 if the total size is unknown or
+// if no data has been transferred yet.
+func (p Progress) ETA() time.Duration {
+	rate := p.Rate()
+	if p.Total < 0 || rate <= 0 {
+		return -1
+	}
+	return time.Duration(float64(p.Total-p.Transferred) / rate * float64(time.Second))
+}
+
+// ProgressFunc is called as a transfer progresses, at most every 100
+// milliseconds, and at the end of the transfer with its final state.
+// The calls of a transfer are serialized, even for a parallel download.
+type ProgressFunc func(p Progress)
+
+// String returns the string representation of Direction d.
+func (d Direction) String() string {
+	return [...]string{"download", "upload"}[d]
+}
+
+// DialWithProgress returns a DialOption that reports the progress of all the
+// data transfers of the connection to f: the downloads with Retr and RetrFrom,
+// the uploads with Stor, StorFrom and Append, and the directory listings.
+//
+// The total size of a download is taken from the reply to RETR when the
+// server includes it. The total size of an upload is known when the
+// io.Reader has a Len method, like bytes.Buffer, or is an *os.File.
+func DialWithProgress(f ProgressFunc) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.progress = f
+	}}
+}
+
+// TransferWithProgress returns a TransferOption that reports the progress of
+// the transfer to f. The total size is always known. It is reported in
+// addition to the progress of the underlying data transfers reported to the
+// function set with DialWithProgress.
+func TransferWithProgress(f ProgressFunc) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.progress = f
+	}}
+}
+
+// progressTracker reports the progress of a transfer to a ProgressFunc.
+// A nil progressTracker reports nothing.
+type progressTracker struct {
+	f     ProgressFunc
+	start time.Time
+
+	mu       sync.Mutex
+	p        Progress
+	reported time.Time // time of the last call of f
+	pending  bool      // the progress since the last call is not reported
+}
+
+// newProgressTracker returns a progressTracker reporting to f the progress of
+// a transfer resumed at offset, or nil if f is nil.
+func newProgressTracker(path string, dir Direction, offset, total int64, f ProgressFunc) *progressTracker {
+	if f == nil {
+		return nil
+	}
+
+	return &progressTracker{
+		f:     f,
+		start: time.Now(),
+		p: Progress{
+			Path:        path,
+			Direction:   dir,
+			Transferred: offset,
+			Total:       total,
+			offset:      offset,
+		},
+	}
+}
+
+// add reports that n more bytes have been transferred
+func (t *progressTracker) add(n int64) {
+	if t == nil || n == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.p.Transferred += n
+	now := time.Now()
+	if t.p.Transferred != t.p.Total && now.Sub(t.reported) < progressInterval {
+		t.pending = true
+		return
+	}
+
+	t.report(now)
+}
+
+// finish reports the progress not reported yet, at the end of the transfer
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending {
+		t.report(time.Now())
+	}
+}
+
+// report calls f with the progress at now, with t.mu held
+func (t *progressTracker) report(now time.Time) {
+	t.p.Elapsed = now.Sub(t.start)
+	t.reported = now
+	t.pending = false
+	t.f(t.p)
+}
+
+// progressWriter reports the bytes written to w
+type progressWriter struct {
+	w        io.Writer
+	progress *progressTracker
+}
+
+func (pw *progressWriter) Write(buf []byte) (int, error) {
+	n, err := pw.w.Write(buf)
+	pw.progress.add(int64(n))
+	return n, err
+}
+
+// progressReader reports the bytes read from r
+type progressReader struct {
+	r        io.Reader
+	progress *progressTracker
+}
+
+func (pr *progressReader) Read(buf []byte) (int, error) {
+	n, err := pr.r.Read(buf)
+	pr.progress.add(int64(n))
+	return n, err
+}
+
+// newProgress returns a progressTracker reporting the progress of a data
+// transfer to the function set with DialWithProgress, or nil.
+func (c *ServerConn) newProgress(path string, dir Direction, offset, total int64) *progressTracker {
+	return newProgressTracker(path, dir, offset, total, c.options.progress)
+}
+
+// parseTransferSize returns the size announced in the reply to a command
+// opening a data connection, e.g. "Opening BINARY mode data connection for
+// file (1234 bytes)", or -1.
+func parseTransferSize(msg string) int64 {
+	end := strings.LastIndex(msg, " bytes)")
+	if end < 0 {
+		return -1
+	}
+
+	start := strings.LastIndexByte(msg[:end], '(')
+	if start < 0 {
+		return -1
+	}
+
+	size, err := strconv.ParseInt(msg[start+1:end], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// uploadSize returns the number of bytes which will be read from r, or -1
+// if it is unknown.
+func uploadSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
+
+// withProgress returns r reporting the progress of its reads to progress
+func withProgress(r io.Reader, progress *progressTracker) io.Reader {
+	if progress == nil {
+		return r
+	}
+	return &progressReader{r: r, progress: progress}
+}
@@ -0,0 +1,128 @@
+package ftp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialWithProgress(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addDir("/dir")
+
+	var events []Progress
+	c := dialTestServer(t, server, DialWithProgress(func(p Progress) {
+		events = append(events, p)
+	}))
+
+	require.NoError(t, c.Stor("/dir/file", bytes.NewBufferString(testData)))
+	if assert.NotEmpty(events) {
+		last := events[len(events)-1]
+		assert.Equal("/dir/file", last.Path)
+		assert.Equal(DirectionUpload, last.Direction)
+		assert.Equal(int64(len(testData)), last.Transferred)
+		assert.Equal(int64(len(testData)), last.Total)
+	}
+
+	events = nil
+	r, err := c.Retr("/dir/file")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.NoError(err)
+	assert.NoError(r.Close())
+	if assert.NotEmpty(events) {
+		last := events[len(events)-1]
+		assert.Equal("/dir/file", last.Path)
+		assert.Equal(DirectionDownload, last.Direction)
+		assert.Equal(int64(len(testData)), last.Transferred)
+		assert.Equal(int64(len(testData)), last.Total)
+	}
+
+	events = nil
+	_, err = c.List("/dir")
+	assert.NoError(err)
+	if assert.NotEmpty(events) {
+		last := events[len(events)-1]
+		assert.Equal("/dir", last.Path)
+		assert.Equal(DirectionDownload, last.Direction)
+		assert.Equal(int64(-1), last.Total)
+	}
+}
+
+func TestProgressThrottled(t *testing.T) {
+	assert := assert.New(t)
+
+	var events []Progress
+	tracker := newProgressTracker("/file", DirectionDownload, 0, -1, func(p Progress) {
+		events = append(events, p)
+	})
+
+	// Only the first of the quick updates is reported
+	for i := 0; i < 1000; i++ {
+		tracker.add(1)
+	}
+	if assert.Len(events, 1) {
+		assert.Equal(int64(1), events[0].Transferred)
+	}
+
+	time.Sleep(progressInterval)
+	tracker.add(1)
+	if assert.Len(events, 2) {
+		assert.Equal(int64(1001), events[1].Transferred)
+	}
+
+	// The final state is always reported
+	tracker.add(1)
+	tracker.finish()
+	tracker.finish()
+	if assert.Len(events, 3) {
+		assert.Equal(int64(1002), events[2].Transferred)
+	}
+}
+
+func TestProgressThrottledComplete(t *testing.T) {
+	var events []Progress
+	tracker := newProgressTracker("/file", DirectionUpload, 0, 10, func(p Progress) {
+		events = append(events, p)
+	})
+
+	// Reaching the total is reported immediately, not again when finished
+	for i := 0; i < 10; i++ {
+		tracker.add(1)
+	}
+	tracker.finish()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, int64(10), events[1].Transferred)
+	}
+}
+
+func TestProgressRate(t *testing.T) {
+	assert := assert.New(t)
+
+	p := Progress{Transferred: 300, Total: 1000, Elapsed: 2 * time.Second, offset: 100}
+	assert.Equal(float64(100), p.Rate())
+	assert.Equal(7*time.Second, p.ETA())
+
+	p = Progress{Transferred: 300, Total: -1, Elapsed: 2 * time.Second}
+	assert.Equal(time.Duration(-1), p.ETA())
+
+	p = Progress{Total: 1000}
+	assert.Equal(float64(0), p.Rate())
+	assert.Equal(time.Duration(-1), p.ETA())
+}
+
+func TestParseTransferSize(t *testing.T) {
+	for msg, size := range map[string]int64{
+		"Opening BINARY mode data connection for file (1234 bytes).": 1234,
+		"Opening BINARY mode data connection for (a) (56 bytes)":     56,
+		"Opening data connection":                                    -1,
+		"Opening data connection (many bytes)":                       -1,
+	} {
+		assert.Equal(t, size, parseTransferSize(msg), msg)
+	}
+}
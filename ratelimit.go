@@ -0,0 +1,148 @@
+package ftp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the throughput of data connections with a token bucket.
+//
+// A RateLimiter is safe to be used concurrently: sharing one between several
+// connections, or several Pools, keeps all their transfers under a single
+// budget.
+type RateLimiter struct {
+	rate  float64 // bytes per second
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond bytes per
+// second, with bursts of at most burst bytes. A burst lower than 1 defaults to
+// a tenth of a second of transfer.
+func NewRateLimiter(bytesPerSecond, burst int) *RateLimiter {
+	if bytesPerSecond < 1 {
+		bytesPerSecond = 1
+	}
+	if burst < 1 {
+		burst = bytesPerSecond / 10
+		if burst < 1 {
+			burst = 1
+		}
+	}
+
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// DialWithRateLimit returns a DialOption that limits the throughput of the
+// data connections, in both directions, with the given RateLimiter.
+// TransferWithRateLimit overrides it for a single transfer.
+func DialWithRateLimit(limiter *RateLimiter) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.rateLimiter = limiter
+	}}
+}
+
+// TransferWithRateLimit returns a TransferOption that limits the throughput of
+// the transfer with the given RateLimiter instead of the one set with
+// DialWithRateLimit.
+func TransferWithRateLimit(limiter *RateLimiter) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.rateLimiter = limiter
+	}}
+}
+
+// wait blocks until n bytes can be transferred
+func (l *RateLimiter) wait(n int) {
+	if d := l.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// reserve takes n tokens from the bucket and returns the time to wait until
+// the bucket is no longer in debt.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// limitConn returns conn limited by the RateLimiter of the transfer in
+// progress, if any.
+func (c *ServerConn) limitConn(conn net.Conn) net.Conn {
+	if c.rateLimiter == nil {
+		return conn
+	}
+	return &limitedConn{Conn: conn, limiter: c.rateLimiter}
+}
+
+// overrideRateLimit replaces the RateLimiter of the connection if limiter is
+// not nil. The returned function restores the previous one.
+func (c *ServerConn) overrideRateLimit(limiter *RateLimiter) (restore func()) {
+	if limiter == nil {
+		return func() {}
+	}
+
+	previous := c.rateLimiter
+	c.rateLimiter = limiter
+	return func() {
+		c.rateLimiter = previous
+	}
+}
+
+// limitedConn is a data connection whose throughput is limited
+type limitedConn struct {
+	net.Conn
+	limiter *RateLimiter
+}
+
+func (lc *limitedConn) Read(buf []byte) (int, error) {
+	if len(buf) > lc.limiter.burst {
+		buf = buf[:lc.limiter.burst]
+	}
+
+	n, err := lc.Conn.Read(buf)
+	lc.limiter.wait(n)
+	return n, err
+}
+
+func (lc *limitedConn) Write(buf []byte) (n int, err error) {
+	for len(buf) > 0 {
+		chunk := buf
+		if len(chunk) > lc.limiter.burst {
+			chunk = chunk[:lc.limiter.burst]
+		}
+
+		lc.limiter.wait(len(chunk))
+
+		var m int
+		m, err = lc.Conn.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		buf = buf[m:]
+	}
+
+	return n, nil
+}
@@ -0,0 +1,102 @@
+package ftp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	assert := assert.New(t)
+	l := NewRateLimiter(1000, 100)
+
+	// The burst is available immediately
+	assert.Equal(time.Duration(0), l.reserve(100))
+
+	// Then the transfers wait for the tokens to be refilled
+	assert.InDelta(float64(100*time.Millisecond), float64(l.reserve(100)), float64(5*time.Millisecond))
+	assert.InDelta(float64(200*time.Millisecond), float64(l.reserve(100)), float64(5*time.Millisecond))
+
+	assert.Equal(100, NewRateLimiter(1000, 0).burst)
+	assert.Equal(1, NewRateLimiter(5, 0).burst)
+}
+
+func TestDialWithRateLimit(t *testing.T) {
+	server := newTestServer(t)
+	data := bytes.Repeat([]byte("x"), 32<<10)
+	server.addFile("/file", data)
+
+	// 8 KiB available at once, then 24 KiB at 64 KiB/s
+	c := dialTestServer(t, server, DialWithRateLimit(NewRateLimiter(64<<10, 8<<10)))
+
+	start := time.Now()
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+	buf, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, data, buf)
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	start = time.Now()
+	assert.NoError(t, c.Stor("/upload", bytes.NewReader(data)))
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	assert.Equal(t, data, server.file("/upload"))
+}
+
+func TestRateLimitShared(t *testing.T) {
+	server := newTestServer(t)
+	data := bytes.Repeat([]byte("x"), 16<<10)
+	server.addFile("/file", data)
+
+	// Both connections share 64 KiB/s
+	limiter := NewRateLimiter(64<<10, 8<<10)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		c := dialTestServer(t, server, DialWithRateLimit(limiter))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r, err := c.Retr("/file")
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = io.Copy(io.Discard, r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+		}()
+	}
+	wg.Wait()
+
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
+
+func TestTransferWithRateLimit(t *testing.T) {
+	server := newTestServer(t)
+	data := bytes.Repeat([]byte("x"), 32<<10)
+	server.addFile("/file", data)
+
+	c := dialTestServer(t, server, DialWithRateLimit(NewRateLimiter(1<<10, 0)))
+
+	// The transfer overrides the limit of the connection
+	local := filepath.Join(t.TempDir(), "file")
+	start := time.Now()
+	require.NoError(t, c.DownloadFile("/file", local, TransferWithRateLimit(NewRateLimiter(1<<30, 0))))
+	assert.Less(t, time.Since(start), time.Second)
+
+	buf, err := os.ReadFile(local)
+	assert.NoError(t, err)
+	assert.Equal(t, data, buf)
+	assert.Equal(t, c.options.rateLimiter, c.rateLimiter)
+}
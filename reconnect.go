@@ -0,0 +1,236 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// RetryPolicy configures how a ServerConn created with DialWithReconnect
+// retries the operations interrupted by the loss of the control connection.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of an operation,
+	// including the first one. The resumptions of a transfer are attempts too.
+	MaxAttempts int
+
+	// InitialDelay is the delay before the first reconnection. It is doubled
+	// before each following reconnection, up to MaxDelay.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DefaultRetryPolicy is a reasonable RetryPolicy for DialWithReconnect.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  3,
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+}
+
+// DialWithReconnect returns a DialOption that makes the ServerConn reconnect
+// when the control connection is lost, for example on EOF, on a 421 "service
+// not available" reply or on a timeout.
+//
+// The ServerConn redials with the same options, logs in again and restores
+// the working directory and the transfer type. Then the idempotent operations
+// (List, NameList, GetEntry, FileSize, GetTime and RetrFrom) are retried as
+// configured by the policy, the wait before a reconnection ending early when
+// the context of the operation is done. A Response returned by Retr or RetrFrom resumes
+// the transfer at the last received offset when the data connection fails.
+//
+// The other operations are not retried, but the connection is restored
+// before the next operation.
+func DialWithReconnect(policy RetryPolicy) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.retryPolicy = &policy
+	}}
+}
+
+// retry executes f, reconnecting and executing it again as configured by
+// DialWithReconnect when it fails because the control connection was lost.
+func (c *ServerConn) retry(f func() error) error {
+	err := f()
+
+	policy := c.options.retryPolicy
+	if policy == nil {
+		return err
+	}
+
+	delay := policy.InitialDelay
+	for attempt := 1; attempt < policy.MaxAttempts && c.isConnLost(err); attempt++ {
+		// Wait for the delay, unless the context of the operation is done
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.watcher.contextDone():
+			timer.Stop()
+			return c.watcher.ctx.Err()
+		}
+		if delay *= 2; delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+
+		if err = c.reconnect(); err == nil {
+			err = f()
+		}
+	}
+
+	return err
+}
+
+// isConnLost reports whether err means that the control connection is lost
+// and that the operation may succeed after a reconnection.
+func (c *ServerConn) isConnLost(err error) bool {
+	if err == nil || c.watcher.interrupted() {
+		return false
+	}
+
+	var ftpErr *Error
+	if errors.As(err, &ftpErr) {
+		return ftpErr.Code == StatusNotAvailable
+	}
+
+	var netErr *net.OpError
+	switch {
+	case errors.Is(err, net.ErrClosed):
+		// The connection was closed on purpose with Quit
+		return false
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, ErrConnBroken):
+		return true
+	case errors.As(err, &netErr):
+		// Includes the timeouts and the failures to dial
+		return true
+	}
+
+	return false
+}
+
+// markLost marks the connection as broken if err means that the control
+// connection is lost, so that the next operation reconnects first.
+func (c *ServerConn) markLost(err error) {
+	if c.options.retryPolicy != nil && c.broken == nil && c.isConnLost(err) {
+		c.broken = fmt.Errorf("%w: %w", ErrConnBroken, err)
+	}
+}
+
+// ensureConn returns the error which made the connection unusable, unless
+// the connection can be restored as configured by DialWithReconnect.
+func (c *ServerConn) ensureConn() error {
+	if c.broken == nil {
+		return nil
+	}
+	if c.options.retryPolicy == nil || c.watcher.interrupted() {
+		return c.broken
+	}
+
+	return c.reconnect()
+}
+
+// reconnect replaces the control connection by a new one, restoring the
+// state of the session.
+func (c *ServerConn) reconnect() error {
+	_ = c.conn.Close()
+
+	do := c.options
+	if do.context != nil && do.context.Err() != nil {
+		// The context given to Dial is done, it only applied to the first connection
+		o := *do
+		o.context = nil
+		do = &o
+	}
+
+	nc, err := dial(c.addr, do)
+	if err != nil {
+		return err
+	}
+
+	// Keep the operations in progress, which include the restoration of the
+	// session, for the hooks
+	nc.operation, nc.transfer = c.operation, c.transfer
+
+	if c.loggedIn {
+		if err := nc.Login(c.user, c.password); err != nil {
+			_ = nc.Quit()
+			return err
+		}
+		if c.transferType != "" && c.transferType != TransferTypeBinary {
+			if err := nc.Type(c.transferType); err != nil {
+				_ = nc.Quit()
+				return err
+			}
+		}
+		if c.cwd != "" {
+			if _, _, err := nc.cmd(StatusRequestedFileActionOK, "CWD %s", c.cwd); err != nil {
+				_ = nc.Quit()
+				return err
+			}
+		}
+	}
+
+	// Keep the session state, take the new connection and capabilities
+	nc.options = c.options
+	nc.user, nc.password, nc.loggedIn = c.user, c.password, c.loggedIn
+	nc.cwd, nc.transferType = c.cwd, c.transferType
+	nc.watcher, nc.response = c.watcher, c.response
+	nc.rateLimiter = c.rateLimiter
+	*c = *nc
+
+	if c.watcher != nil {
+		c.watcher.setControlConn(c.netConn)
+	}
+
+	return nil
+}
+
+// trackDir records the working directory, to restore it when reconnecting.
+func (c *ServerConn) trackDir() error {
+	if c.options.retryPolicy == nil {
+		return nil
+	}
+
+	dir, err := c.CurrentDir()
+	if err != nil {
+		return err
+	}
+
+	c.cwd = dir
+	return nil
+}
+
+// resumable reports whether the transfer can be resumed after err, the first
+// attempt and the resumptions being limited to the MaxAttempts of the policy.
+func (r *Response) resumable(err error) bool {
+	policy := r.c.options.retryPolicy
+	if policy == nil || r.path == "" || r.c.watcher.interrupted() {
+		return false
+	}
+	if r.resumes+1 >= policy.MaxAttempts {
+		return false
+	}
+
+	// Deadlines set with SetDeadline are not failures
+	return !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// resume restarts the transfer at the last received offset, reconnecting if
+// the control connection is lost too.
+func (r *Response) resume() error {
+	c := r.c
+	_ = r.conn.Close()
+
+	// Read the status of the failed transfer. If the control connection is
+	// lost, the retry below will reconnect.
+	_ = c.checkDataShut()
+
+	return c.retry(func() error {
+		conn, err := c.cmdDataConnFrom(r.offset, "RETR %s", r.path)
+		if err != nil {
+			return err
+		}
+
+		r.conn = conn
+		return nil
+	})
+}
@@ -0,0 +1,204 @@
+package ftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:  3,
+	InitialDelay: time.Millisecond,
+	MaxDelay:     10 * time.Millisecond,
+}
+
+// failOnce returns a handler which calls fail the first time and handles the
+// command with the default behavior afterwards
+func failOnce(command string, fail func(sess *testSession, arg string)) func(sess *testSession, arg string) {
+	var once sync.Once
+	return func(sess *testSession, arg string) {
+		failed := false
+		once.Do(func() {
+			fail(sess, arg)
+			failed = true
+		})
+		if !failed {
+			sess.builtin(command, arg)
+		}
+	}
+}
+
+func TestReconnectRestoresSession(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/dir/file", []byte(testData))
+
+	var types []string
+	server.handle("TYPE", func(sess *testSession, arg string) {
+		types = append(types, arg)
+		sess.builtin("TYPE", arg)
+	})
+	server.handle("SIZE", failOnce("SIZE", func(sess *testSession, arg string) {
+		_ = sess.conn.Close()
+	}))
+
+	c := dialTestServer(t, server, DialWithReconnect(testRetryPolicy))
+	require.NoError(t, c.ChangeDir("dir"))
+	require.NoError(t, c.Type(TransferTypeASCII))
+
+	// The relative path is resolved in the restored working directory
+	size, err := c.FileSize("file")
+	assert.NoError(err)
+	assert.Equal(int64(len(testData)), size)
+
+	assert.Equal(2, server.connCount())
+	assert.Equal([]string{"I", "A", "I", "A"}, types)
+}
+
+func TestReconnectServiceNotAvailable(t *testing.T) {
+	server := newTestServer(t)
+	server.addDir("/dir")
+	server.handle("MLSD", failOnce("MLSD", func(sess *testSession, arg string) {
+		sess.closeData()
+		sess.reply("421 Service not available, closing control connection")
+		_ = sess.conn.Close()
+	}))
+
+	c := dialTestServer(t, server, DialWithReconnect(testRetryPolicy))
+
+	_, err := c.List("/dir")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, server.connCount())
+}
+
+func TestReconnectDelayCanceled(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	server.handle("SIZE", func(sess *testSession, arg string) {
+		_ = sess.conn.Close()
+	})
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Hour, MaxDelay: time.Hour}
+	c := dialTestServer(t, server, DialWithReconnect(policy))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.FileSizeContext(ctx, "/file")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 1, server.connCount())
+}
+
+func TestReconnectNotRetried(t *testing.T) {
+	server := newTestServer(t)
+	server.handle("MKD", failOnce("MKD", func(sess *testSession, arg string) {
+		_ = sess.conn.Close()
+	}))
+
+	c := dialTestServer(t, server, DialWithReconnect(testRetryPolicy))
+
+	// MKD is not idempotent, it is not retried
+	assert.Error(t, c.MakeDir("/dir"))
+
+	// But the connection is restored for the next operation
+	assert.NoError(t, c.MakeDir("/dir"))
+	assert.Equal(t, 2, server.connCount())
+}
+
+func TestReconnectDisabled(t *testing.T) {
+	server := newTestServer(t)
+	server.handle("SIZE", func(sess *testSession, arg string) {
+		_ = sess.conn.Close()
+	})
+
+	c := dialTestServer(t, server)
+
+	_, err := c.FileSize("/file")
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.connCount())
+}
+
+func TestReconnectResumesTransfer(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+
+	var rest []int64
+	server.handle("RETR", failOnce("RETR", func(sess *testSession, arg string) {
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+
+		sess.reply("150 Opening data connection")
+		_, _ = conn.Write([]byte(testData[:5]))
+
+		// Reset the data connection in the middle of the transfer
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+		sess.reply("426 Transfer aborted")
+	}))
+	server.handle("REST", func(sess *testSession, arg string) {
+		sess.builtin("REST", arg)
+		rest = append(rest, sess.rest)
+	})
+
+	c := dialTestServer(t, server, DialWithReconnect(testRetryPolicy))
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+
+	buf, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, testData, string(buf))
+	assert.NoError(t, r.Close())
+
+	assert.Equal(t, []int64{5}, rest)
+	assert.Equal(t, 1, server.connCount())
+}
+
+func TestReconnectResumeAttempts(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+
+	var retrs int
+	server.handle("RETR", func(sess *testSession, arg string) {
+		retrs++
+		rest := sess.rest
+		sess.rest = 0
+
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+
+		sess.reply("150 Opening data connection")
+		_, _ = conn.Write([]byte(testData[rest : rest+2]))
+
+		// Reset the data connection every time
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+		sess.reply("426 Transfer aborted")
+	})
+
+	c := dialTestServer(t, server, DialWithReconnect(testRetryPolicy))
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+
+	// The transfer is resumed twice, then the error is returned
+	buf, err := io.ReadAll(r)
+	assert.Error(t, err)
+	assert.Equal(t, testData[:6], string(buf))
+	assert.Equal(t, testRetryPolicy.MaxAttempts, retrs)
+	_ = r.Close()
+}
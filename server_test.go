@@ -0,0 +1,813 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is an in-memory FTP server.
+// Unlike ftpMock, it accepts any number of concurrent connections which all
+// share the same file tree.
+type testServer struct {
+	t         *testing.T
+	listener  net.Listener
+	tlsConfig *tls.Config // set by enableTLS
+
+	mu       sync.Mutex
+	files    map[string]*testFile // keyed by absolute path
+	sessions map[*testSession]struct{}
+	handlers map[string]func(sess *testSession, arg string)
+	received []string // command lines received by all the sessions
+	accepted int
+	wg       sync.WaitGroup
+}
+
+// testFile is a file or a directory of a testServer
+type testFile struct {
+	dir     bool
+	data    []byte
+	modTime time.Time
+}
+
+// testSession is the state of a single control connection
+type testSession struct {
+	server     *testServer
+	rawConn    net.Conn // closed by testServer.Close
+	conn       net.Conn // rawConn, protected by TLS after AUTH TLS
+	proto      *textproto.Conn
+	cwd        string
+	rest       int64
+	renameFrom string
+	hashAlgo   HashAlgorithm // set with OPTS HASH
+
+	dataListener net.Listener // set by PASV and EPSV
+	dataAddr     string       // set by PORT and EPRT
+	protected    bool         // set by PROT P, the data connections use TLS
+	transfer     *testTransfer
+}
+
+// testTransfer is a download running in the background, so that it can be
+// aborted
+type testTransfer struct {
+	conn net.Conn
+	done chan struct{}
+}
+
+// testModTime is the modification time of the files created by addFile
+var testModTime = time.Date(2020, time.December, 13, 20, 24, 0, 0, time.UTC)
+
+// newTestServer starts a testServer which is closed with the test
+func newTestServer(t *testing.T) *testServer {
+	return newTestServerAt(t, "127.0.0.1")
+}
+
+// newTestServerAt starts a testServer listening on the given host
+func newTestServerAt(t *testing.T, host string) *testServer {
+	l, err := net.Listen("tcp", net.JoinHostPort(strings.Trim(host, "[]"), "0"))
+	require.NoError(t, err)
+
+	s := &testServer{
+		t:        t,
+		listener: l,
+		files: map[string]*testFile{
+			"/": {dir: true, modTime: testModTime},
+		},
+		sessions: make(map[*testSession]struct{}),
+		handlers: make(map[string]func(sess *testSession, arg string)),
+	}
+
+	go s.serve()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops listening and closes all the sessions
+func (s *testServer) Close() {
+	_ = s.listener.Close()
+
+	s.mu.Lock()
+	for sess := range s.sessions {
+		_ = sess.rawConn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// connCount returns the number of control connections accepted so far
+func (s *testServer) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// sessionCount returns the number of control connections currently open
+func (s *testServer) sessionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// receivedLines returns the command lines received so far
+func (s *testServer) receivedLines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.received...)
+}
+
+// enableTLS makes the server accept AUTH TLS with a self-signed certificate,
+// and returns a client configuration trusting it.
+func (s *testServer) enableTLS() *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(s.t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test server"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(s.t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(s.t, err)
+
+	s.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+// handle overrides the handling of a command
+func (s *testServer) handle(command string, f func(sess *testSession, arg string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[command] = f
+}
+
+// addFile creates a file and its parent directories
+func (s *testServer) addFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mkdirAll(path.Dir(name))
+	s.files[name] = &testFile{data: data, modTime: testModTime}
+}
+
+// addDir creates a directory and its parents
+func (s *testServer) addDir(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mkdirAll(name)
+}
+
+func (s *testServer) mkdirAll(name string) {
+	for ; name != "/"; name = path.Dir(name) {
+		if _, ok := s.files[name]; !ok {
+			s.files[name] = &testFile{dir: true, modTime: testModTime}
+		}
+	}
+}
+
+// file returns a copy of the content of a file, or nil if it does not exist
+func (s *testServer) file(name string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[name]
+	if !ok || f.dir {
+		return nil
+	}
+	return append([]byte{}, f.data...)
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		sess := &testSession{
+			server:  s,
+			rawConn: conn,
+			conn:    conn,
+			proto:   textproto.NewConn(conn),
+			cwd:     "/",
+		}
+
+		s.mu.Lock()
+		s.accepted++
+		s.sessions[sess] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			sess.serve()
+
+			s.mu.Lock()
+			delete(s.sessions, sess)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (sess *testSession) reply(format string, args ...interface{}) {
+	// Errors are detected by the client
+	_ = sess.proto.PrintfLine(format, args...)
+}
+
+// resolve returns the absolute path of name
+func (sess *testSession) resolve(name string) string {
+	if !path.IsAbs(name) {
+		name = path.Join(sess.cwd, name)
+	}
+	return path.Clean(name)
+}
+
+func (sess *testSession) serve() {
+	defer func() {
+		if sess.transfer != nil {
+			_ = sess.transfer.conn.Close()
+			<-sess.transfer.done
+		}
+	}()
+	defer sess.closeData()
+	defer sess.conn.Close()
+
+	sess.reply("220 Test server ready")
+
+	for {
+		line, err := sess.proto.ReadLine()
+		if err != nil {
+			return
+		}
+
+		// Skip the Telnet signals sent before ABOR
+		line = strings.TrimLeftFunc(line, func(r rune) bool {
+			return r == utf8.RuneError
+		})
+
+		s := sess.server
+		s.mu.Lock()
+		s.received = append(s.received, line)
+		s.mu.Unlock()
+
+		command, arg, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+
+		if sess.transfer != nil {
+			if command == "ABOR" {
+				_ = sess.transfer.conn.Close()
+			}
+			<-sess.transfer.done
+			sess.transfer = nil
+
+			if command == "ABOR" {
+				sess.reply("226 Abort successful")
+				continue
+			}
+		}
+
+		if command == "QUIT" {
+			sess.reply("221 Goodbye")
+			return
+		}
+
+		sess.handle(command, arg)
+	}
+}
+
+func (sess *testSession) handle(command, arg string) {
+	s := sess.server
+
+	s.mu.Lock()
+	handler := s.handlers[command]
+	s.mu.Unlock()
+	if handler != nil {
+		handler(sess, arg)
+		return
+	}
+
+	sess.builtin(command, arg)
+}
+
+// builtin handles a command with the default behavior
+func (sess *testSession) builtin(command, arg string) {
+	s := sess.server
+
+	switch command {
+	case "AUTH":
+		if s.tlsConfig == nil || !strings.EqualFold(arg, "TLS") {
+			sess.reply("504 Security mechanism not supported")
+			return
+		}
+		sess.reply("234 Proceed with negotiation")
+		sess.conn = tls.Server(sess.conn, s.tlsConfig)
+		sess.proto = textproto.NewConn(sess.conn)
+	case "PBSZ":
+		sess.reply("200 PBSZ=0")
+	case "PROT":
+		sess.protected = arg == "P"
+		sess.reply("200 Protection level set")
+	case "USER":
+		sess.reply("331 Password required")
+	case "PASS":
+		sess.reply("230 Logged in")
+	case "FEAT":
+		sess.reply("211-Features:\r\n MLST type*;size*;modify*;\r\n SIZE\r\n MDTM\r\n MFMT\r\n EPSV\r\n PASV\r\n UTF8\r\n REST STREAM\r\n HASH SHA-256*;SHA-1;MD5;CRC32\r\n XMD5\r\n XCRC\r\n211 End")
+	case "TYPE":
+		sess.reply("200 OK")
+	case "OPTS":
+		if name, ok := strings.CutPrefix(arg, "HASH "); ok {
+			algo := HashAlgorithm(strings.ToUpper(name))
+			if algo.NewHash() == nil {
+				sess.reply("501 Unknown algorithm")
+				return
+			}
+			sess.hashAlgo = algo
+			sess.reply("200 %s", algo)
+			return
+		}
+		sess.reply("200 OK")
+	case "NOOP":
+		sess.reply("200 NOOP ok")
+	case "REIN":
+		sess.reply("220 Logged out")
+	case "ABOR":
+		sess.reply("225 No transfer to abort")
+	case "PWD":
+		sess.reply("257 \"%s\" is the current directory", sess.cwd)
+	case "CWD", "CDUP":
+		if command == "CDUP" {
+			arg = ".."
+		}
+		name := sess.resolve(arg)
+		if f := s.lookup(name); f == nil || !f.dir {
+			sess.reply("550 %s: No such directory", arg)
+			return
+		}
+		sess.cwd = name
+		sess.reply("250 Directory changed")
+	case "MKD":
+		name := sess.resolve(arg)
+		s.mu.Lock()
+		_, exists := s.files[name]
+		parent, parentExists := s.files[path.Dir(name)]
+		if !exists && parentExists && parent.dir {
+			s.files[name] = &testFile{dir: true, modTime: time.Now()}
+		}
+		s.mu.Unlock()
+		if exists || !parentExists || !parent.dir {
+			sess.reply("550 %s: Can not create directory", arg)
+			return
+		}
+		sess.reply("257 \"%s\" created", name)
+	case "RMD", "DELE":
+		if err := s.remove(sess.resolve(arg), command == "RMD"); err != nil {
+			sess.reply("550 %s: %s", arg, err)
+			return
+		}
+		sess.reply("250 Removed")
+	case "RNFR":
+		name := sess.resolve(arg)
+		if s.lookup(name) == nil {
+			sess.reply("550 %s: No such file or directory", arg)
+			return
+		}
+		sess.renameFrom = name
+		sess.reply("350 Ready for destination name")
+	case "RNTO":
+		if err := s.rename(sess.renameFrom, sess.resolve(arg)); err != nil {
+			sess.reply("550 %s: %s", arg, err)
+			return
+		}
+		sess.renameFrom = ""
+		sess.reply("250 Renamed")
+	case "SIZE":
+		f := s.lookup(sess.resolve(arg))
+		if f == nil || f.dir {
+			sess.reply("550 %s: No such file", arg)
+			return
+		}
+		sess.reply("213 %d", len(f.data))
+	case "MDTM":
+		f := s.lookup(sess.resolve(arg))
+		if f == nil {
+			sess.reply("550 %s: No such file", arg)
+			return
+		}
+		sess.reply("213 %s", f.modTime.UTC().Format(timeFormat))
+	case "HASH", "XMD5", "XCRC":
+		f := s.lookup(sess.resolve(arg))
+		if f == nil || f.dir {
+			sess.reply("550 %s: No such file", arg)
+			return
+		}
+		algo := map[string]HashAlgorithm{"HASH": sess.hashAlgo, "XMD5": HashMD5, "XCRC": HashCRC32}[command]
+		if algo == "" {
+			algo = HashSHA256
+		}
+		h := algo.NewHash()
+		s.mu.Lock()
+		h.Write(f.data)
+		size := len(f.data)
+		s.mu.Unlock()
+		if command == "HASH" {
+			sess.reply("213 %s 0-%d %x %s", algo, size, h.Sum(nil), arg)
+		} else {
+			sess.reply("250 %X", h.Sum(nil))
+		}
+	case "MFMT":
+		value, name, _ := strings.Cut(arg, " ")
+		t, err := time.ParseInLocation(timeFormat, value, time.UTC)
+		if err != nil {
+			sess.reply("501 Invalid time")
+			return
+		}
+		s.mu.Lock()
+		f := s.files[sess.resolve(name)]
+		if f != nil {
+			f.modTime = t
+		}
+		s.mu.Unlock()
+		if f == nil {
+			sess.reply("550 %s: No such file", name)
+			return
+		}
+		sess.reply("213 Modify=%s; %s", value, name)
+	case "MLST":
+		name := sess.resolve(arg)
+		f := s.lookup(name)
+		if f == nil {
+			sess.reply("550 %s: No such file or directory", arg)
+			return
+		}
+		sess.reply("250-File details\r\n %s\r\n250 End", f.facts(path.Base(name)))
+	case "REST":
+		rest, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			sess.reply("501 Invalid offset")
+			return
+		}
+		sess.rest = rest
+		sess.reply("350 Restarting at %d", rest)
+	case "PASV", "EPSV":
+		sess.closeData()
+		ip := sess.conn.LocalAddr().(*net.TCPAddr).IP
+		l, err := net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+		sess.dataListener = l
+		port := l.Addr().(*net.TCPAddr).Port
+		if command == "PASV" {
+			ip4 := ip.To4()
+			if ip4 == nil {
+				sess.reply("522 Use EPSV")
+				return
+			}
+			sess.reply("227 Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip4[0], ip4[1], ip4[2], ip4[3], port/256, port%256)
+		} else {
+			sess.reply("229 Entering Extended Passive Mode (|||%d|)", port)
+		}
+	case "PORT", "EPRT":
+		sess.closeData()
+		var addr string
+		var err error
+		if command == "PORT" {
+			addr, err = parsePORTArg(arg)
+		} else {
+			addr, err = parseEPRTArg(arg)
+		}
+		if err != nil {
+			sess.reply("501 %s", err)
+			return
+		}
+		sess.dataAddr = addr
+		sess.reply("200 %s command successful", command)
+	case "LIST", "MLSD", "NLST":
+		sess.list(command, arg)
+	case "RETR":
+		sess.retr(arg)
+	case "STOR", "APPE":
+		sess.stor(arg, command == "APPE")
+	default:
+		sess.reply("502 Command %s not implemented", command)
+	}
+}
+
+// lookup returns the file of the given absolute path, or nil
+func (s *testServer) lookup(name string) *testFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files[name]
+}
+
+func (s *testServer) children(dir string) []string {
+	var names []string
+	for name := range s.files {
+		if name != "/" && path.Dir(name) == dir {
+			names = append(names, path.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *testServer) remove(name string, dir bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[name]
+	switch {
+	case !ok || name == "/":
+		return errors.New("No such file or directory")
+	case f.dir != dir:
+		return errors.New("Wrong file type")
+	case dir && len(s.children(name)) > 0:
+		return errors.New("Directory not empty")
+	}
+
+	delete(s.files, name)
+	return nil
+}
+
+func (s *testServer) rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[from]
+	if !ok || from == "" {
+		return errors.New("No such file or directory")
+	}
+	if parent, ok := s.files[path.Dir(to)]; !ok || !parent.dir {
+		return errors.New("No such directory")
+	}
+
+	for name, child := range s.files {
+		if strings.HasPrefix(name, from+"/") {
+			delete(s.files, name)
+			s.files[to+strings.TrimPrefix(name, from)] = child
+		}
+	}
+	delete(s.files, from)
+	s.files[to] = f
+	return nil
+}
+
+// facts returns the RFC 3659 facts of a file
+func (f *testFile) facts(name string) string {
+	if f.dir {
+		return fmt.Sprintf("type=dir;modify=%s; %s", f.modTime.UTC().Format(timeFormat), name)
+	}
+	return fmt.Sprintf("type=file;size=%d;modify=%s; %s", len(f.data), f.modTime.UTC().Format(timeFormat), name)
+}
+
+// lsLine returns a line in the format of ls -l
+func (f *testFile) lsLine(name string) string {
+	mode := "-rw-r--r--"
+	if f.dir {
+		mode = "drwxr-xr-x"
+	}
+	return fmt.Sprintf("%s 1 ftp ftp %d %s %s", mode, len(f.data), f.modTime.UTC().Format("Jan _2  2006"), name)
+}
+
+// openData establishes the data connection prepared by PASV, EPSV, PORT or
+// EPRT, protected with TLS after PROT P
+func (sess *testSession) openData() (net.Conn, error) {
+	defer sess.closeData()
+
+	var conn net.Conn
+	var err error
+	switch {
+	case sess.dataListener != nil:
+		if err := sess.dataListener.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			return nil, err
+		}
+		conn, err = sess.dataListener.Accept()
+	case sess.dataAddr != "":
+		conn, err = net.DialTimeout("tcp", sess.dataAddr, 5*time.Second)
+	default:
+		return nil, errors.New("no data connection")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sess.protected {
+		return tls.Server(conn, sess.server.tlsConfig), nil
+	}
+	return conn, nil
+}
+
+func (sess *testSession) closeData() {
+	if sess.dataListener != nil {
+		_ = sess.dataListener.Close()
+		sess.dataListener = nil
+	}
+	sess.dataAddr = ""
+}
+
+// send writes data on a new data connection, in the background
+func (sess *testSession) send(data []byte) {
+	conn, err := sess.openData()
+	if err != nil {
+		sess.reply("425 %s", err)
+		return
+	}
+
+	sess.reply("150 Opening data connection (%d bytes)", len(data))
+
+	transfer := &testTransfer{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	sess.transfer = transfer
+
+	go func() {
+		defer close(transfer.done)
+
+		_, err := conn.Write(data)
+		if errClose := conn.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			sess.reply("426 Transfer aborted")
+			return
+		}
+		sess.reply("226 Transfer complete")
+	}()
+}
+
+func (sess *testSession) list(command, arg string) {
+	s := sess.server
+
+	if strings.HasPrefix(arg, "-a") {
+		arg = strings.TrimSpace(arg[2:])
+	}
+	dir := sess.resolve(arg)
+
+	s.mu.Lock()
+	f, ok := s.files[dir]
+	var buf bytes.Buffer
+	if ok && f.dir {
+		for _, name := range s.children(dir) {
+			child := s.files[path.Join(dir, name)]
+			switch command {
+			case "MLSD":
+				buf.WriteString(child.facts(name))
+			case "LIST":
+				buf.WriteString(child.lsLine(name))
+			case "NLST":
+				buf.WriteString(name)
+			}
+			buf.WriteString("\r\n")
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok || !f.dir {
+		sess.closeData()
+		sess.reply("550 %s: No such directory", arg)
+		return
+	}
+
+	sess.send(buf.Bytes())
+}
+
+func (sess *testSession) retr(arg string) {
+	f := sess.server.lookup(sess.resolve(arg))
+	rest := sess.rest
+	sess.rest = 0
+
+	if f == nil || f.dir {
+		sess.closeData()
+		sess.reply("550 %s: No such file", arg)
+		return
+	}
+
+	sess.server.mu.Lock()
+	data := f.data
+	sess.server.mu.Unlock()
+	if rest > int64(len(data)) {
+		rest = int64(len(data))
+	}
+
+	sess.send(data[rest:])
+}
+
+func (sess *testSession) stor(arg string, appendData bool) {
+	s := sess.server
+	name := sess.resolve(arg)
+	rest := sess.rest
+	sess.rest = 0
+
+	if parent := s.lookup(path.Dir(name)); parent == nil || !parent.dir {
+		sess.closeData()
+		sess.reply("553 %s: No such directory", arg)
+		return
+	}
+
+	conn, err := sess.openData()
+	if err != nil {
+		sess.reply("425 %s", err)
+		return
+	}
+
+	sess.reply("150 Ok to send data")
+	data, err := io.ReadAll(conn)
+	_ = conn.Close()
+	if err != nil {
+		sess.reply("426 %s", err)
+		return
+	}
+
+	s.mu.Lock()
+	f, ok := s.files[name]
+	if !ok || f.dir {
+		f = &testFile{}
+		s.files[name] = f
+	}
+	switch {
+	case appendData:
+		f.data = append(f.data, data...)
+	case rest > 0 && rest <= int64(len(f.data)):
+		f.data = append(f.data[:rest:rest], data...)
+	default:
+		f.data = data
+	}
+	f.modTime = time.Now()
+	s.mu.Unlock()
+
+	sess.reply("226 Transfer complete")
+}
+
+// parsePORTArg parses the h1,h2,h3,h4,p1,p2 argument of a PORT command
+func parsePORTArg(arg string) (string, error) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 6 {
+		return "", errors.New("invalid PORT argument")
+	}
+
+	p1, err := strconv.Atoi(parts[4])
+	if err != nil {
+		return "", err
+	}
+	p2, err := strconv.Atoi(parts[5])
+	if err != nil {
+		return "", err
+	}
+
+	host := strings.Join(parts[:4], ".")
+	return net.JoinHostPort(host, strconv.Itoa(p1*256+p2)), nil
+}
+
+// parseEPRTArg parses the |proto|host|port| argument of an EPRT command
+func parseEPRTArg(arg string) (string, error) {
+	parts := strings.Split(arg, "|")
+	if len(parts) != 5 {
+		return "", errors.New("invalid EPRT argument")
+	}
+
+	return net.JoinHostPort(parts[2], parts[3]), nil
+}
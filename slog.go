@@ -0,0 +1,86 @@
+//go:build go1.21
+
+package ftp
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// DialWithLogger returns a DialOption that logs the activity of the
+// ServerConn as structured records at the debug level: the commands with the
+// code and the latency of their reply, the data connections and the end of
+// the transfers.
+//
+// Unlike DialWithDebugOutput, the arguments of the PASS and ACCT commands are
+// redacted, and the contents of the data connections are only logged when
+// enabled with DialWithDataLogging.
+func DialWithLogger(logger *slog.Logger) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.logger = &slogLogger{logger: logger}
+	}}
+}
+
+// DialWithDataLogging returns a DialOption that enables the logging of the
+// contents of the data connections by the logger set with DialWithLogger.
+// It is disabled by default, since the transferred files may be large or
+// contain sensitive data.
+func DialWithDataLogging(enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.logData = enabled
+	}}
+}
+
+// slogLogger is a connLogger writing to a slog.Logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) logCommand(line string, code int, latency time.Duration, err error) {
+	attrs := []slog.Attr{
+		slog.String("command", line),
+		slog.Int("code", code),
+		slog.Duration("latency", latency),
+	}
+	l.log("ftp command", attrs, err)
+}
+
+func (l *slogLogger) logDataConn(command string, active bool, conn net.Conn) {
+	mode := "passive"
+	if active {
+		mode = "active"
+	}
+
+	l.log("ftp data connection", []slog.Attr{
+		slog.String("command", command),
+		slog.String("mode", mode),
+		slog.String("local", conn.LocalAddr().String()),
+		slog.String("remote", conn.RemoteAddr().String()),
+	}, nil)
+}
+
+func (l *slogLogger) logTransfer(command string, code int, latency time.Duration, err error) {
+	attrs := []slog.Attr{
+		slog.String("command", command),
+		slog.Int("code", code),
+		slog.Duration("latency", latency),
+	}
+	l.log("ftp transfer", attrs, err)
+}
+
+func (l *slogLogger) logData(command string, dir Direction, data []byte) {
+	l.log("ftp data", []slog.Attr{
+		slog.String("command", command),
+		slog.String("direction", dir.String()),
+		slog.String("data", string(data)),
+	}, nil)
+}
+
+func (l *slogLogger) log(msg string, attrs []slog.Attr, err error) {
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	l.logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+}
@@ -0,0 +1,125 @@
+//go:build go1.21
+
+package ftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logRecords returns the records written by a JSON handler
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for {
+		var record map[string]any
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, record)
+	}
+}
+
+func dialLoggedTestServer(t *testing.T, buf *bytes.Buffer, options ...DialOption) *ServerConn {
+	server := newTestServer(t)
+	server.addFile("/file", []byte("file contents"))
+
+	handler := slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	options = append(options, DialWithLogger(slog.New(handler)))
+	c, err := Dial(server.Addr(), options...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.Quit()
+	})
+
+	require.NoError(t, c.Login("user", "secret"))
+	return c
+}
+
+func TestLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := dialLoggedTestServer(t, buf)
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	_, err = c.FileSize("/missing")
+	require.Error(t, err)
+
+	assert.NotContains(t, buf.String(), "secret")
+	assert.NotContains(t, buf.String(), "file contents")
+
+	var commands []string
+	var transfers, dataConns int
+	for _, record := range logRecords(t, buf) {
+		assert.Equal(t, "DEBUG", record["level"])
+
+		switch record["msg"] {
+		case "ftp command":
+			commands = append(commands, record["command"].(string))
+			assert.Contains(t, record, "latency")
+			if strings.HasPrefix(record["command"].(string), "SIZE") {
+				assert.EqualValues(t, StatusFileUnavailable, record["code"])
+				assert.Contains(t, record["error"], "550")
+			}
+		case "ftp data connection":
+			dataConns++
+			assert.Equal(t, "RETR", record["command"])
+			assert.Equal(t, "passive", record["mode"])
+		case "ftp transfer":
+			transfers++
+			assert.Equal(t, "RETR", record["command"])
+			assert.EqualValues(t, StatusClosingDataConnection, record["code"])
+		case "ftp data":
+			t.Errorf("unexpected data record: %v", record)
+		}
+	}
+
+	assert.Contains(t, commands, "USER user")
+	assert.Contains(t, commands, "PASS ****")
+	assert.Contains(t, commands, "RETR /file")
+	assert.Contains(t, commands, "SIZE /missing")
+	assert.Equal(t, 1, dataConns)
+	assert.Equal(t, 1, transfers)
+}
+
+func TestLoggerData(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := dialLoggedTestServer(t, buf, DialWithDataLogging(true))
+
+	require.NoError(t, c.Stor("/upload", strings.NewReader("uploaded contents")))
+
+	r, err := c.Retr("/file")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	var downloaded, uploaded string
+	for _, record := range logRecords(t, buf) {
+		if record["msg"] != "ftp data" {
+			continue
+		}
+		switch record["direction"] {
+		case DirectionDownload.String():
+			downloaded += record["data"].(string)
+		case DirectionUpload.String():
+			uploaded += record["data"].(string)
+		}
+	}
+
+	assert.Equal(t, "file contents", downloaded)
+	assert.Equal(t, "uploaded contents", uploaded)
+}
@@ -0,0 +1,378 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// DefaultPartSuffix is the suffix of the file in which DownloadFile and
+// UploadFile write the data until the transfer is complete.
+const DefaultPartSuffix = ".part"
+
+// DefaultTransferRetries is the default number of times a failed segment of
+// a parallel download is retried.
+const DefaultTransferRetries = 3
+
+// ErrSizeMismatch is returned when the size of a transferred file does not
+// match the size of its source.
+var ErrSizeMismatch = errors.New("ftp: size mismatch")
+
+// TransferOption represents an option of the file transfer helpers such as
+// DownloadFile and UploadFile.
+type TransferOption struct {
+	setup func(to *transferOptions)
+}
+
+// transferOptions contains all the options set by TransferOption.setup
+type transferOptions struct {
+	partSuffix    string
+	noPartFile    bool
+	noResume      bool
+	preserveMtime bool
+	progress      ProgressFunc
+	rateLimiter   *RateLimiter
+	segments      int
+	retries       int
+	include       []string
+	exclude       []string
+	symlinks      SymlinkPolicy
+	concurrency   int
+	dryRun        func(remote string, info fs.FileInfo)
+}
+
+func newTransferOptions(options []TransferOption) *transferOptions {
+	to := &transferOptions{
+		partSuffix: DefaultPartSuffix,
+		retries:    DefaultTransferRetries,
+	}
+	for _, option := range options {
+		option.setup(to)
+	}
+	return to
+}
+
+// TransferWithPartSuffix returns a TransferOption that changes the suffix of
+// the partial file written by DownloadFile and UploadFile.
+func TransferWithPartSuffix(suffix string) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.partSuffix = suffix
+	}}
+}
+
+// TransferWithPartFile returns a TransferOption that enables or disables the
+// use of a partial file, renamed into place once the transfer is complete.
+// When disabled, the data is written directly to the destination, and
+// UploadFile does not resume since it cannot tell an interrupted upload from
+// an older version of the remote file. It is enabled by default.
+func TransferWithPartFile(enabled bool) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.noPartFile = !enabled
+	}}
+}
+
+// TransferWithResume returns a TransferOption that enables or disables the
+// resumption of an interrupted transfer. It is enabled by default.
+func TransferWithResume(enabled bool) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.noResume = !enabled
+	}}
+}
+
+// TransferWithPreservedModTime returns a TransferOption that makes UploadFile
+// set the modification time of the remote file to the one of the local file
+// with SetTime. DownloadFile always preserves the modification time.
+func TransferWithPreservedModTime(enabled bool) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.preserveMtime = enabled
+	}}
+}
+
+// TransferWithSegments returns a TransferOption that sets the number of
+// segments, and thus of connections, of Pool.DownloadParallel.
+func TransferWithSegments(n int) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.segments = n
+	}}
+}
+
+// TransferWithRetries returns a TransferOption that sets the number of times
+// a failed segment of Pool.DownloadParallel is retried.
+func TransferWithRetries(n int) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.retries = n
+	}}
+}
+
+// part returns the path to which the data of a transfer to dest is written
+func (to *transferOptions) part(dest string) string {
+	if to.noPartFile {
+		return dest
+	}
+	return dest + to.partSuffix
+}
+
+// DownloadFile downloads the remote file to the local path.
+//
+// The data is written to the local path suffixed with ".part" which is renamed
+// to the local path once the download is complete and its size has been
+// verified. The modification time of the partial file is set to the one of the
+// remote file, so that a later call resumes an interrupted download unless the
+// remote file has changed in between. The download restarts from the
+// beginning when the size or the modification time of the remote file is not
+// available.
+func (c *ServerConn) DownloadFile(remote, local string, options ...TransferOption) error {
+	to := newTransferOptions(options)
+	defer c.overrideRateLimit(to.rateLimiter)()
+
+	size, err := c.FileSize(remote)
+	if err != nil {
+		if !isProtocolError(err) {
+			return err
+		}
+		size = -1
+	}
+
+	var mtime time.Time
+	if c.IsGetTimeSupported() {
+		mtime, err = c.GetTime(remote)
+		if err != nil && !isProtocolError(err) {
+			return err
+		}
+	}
+
+	part := to.part(local)
+	var offset int64
+	if !to.noResume && size >= 0 && !mtime.IsZero() {
+		offset = resumeOffset(part, size, mtime)
+	}
+
+	progress := newProgressTracker(remote, DirectionDownload, offset, size, to.progress)
+
+	written, err := c.downloadPart(remote, part, offset, progress)
+	progress.finish()
+
+	if !mtime.IsZero() {
+		// Allows to resume the download later, even after a failure
+		if errTime := os.Chtimes(part, mtime, mtime); err == nil {
+			err = errTime
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if size >= 0 && offset+written != size {
+		_ = os.Remove(part)
+		return fmt.Errorf("%w: downloaded %d bytes of %s, expected %d", ErrSizeMismatch, offset+written, remote, size)
+	}
+
+	if part == local {
+		return nil
+	}
+	return os.Rename(part, local)
+}
+
+// resumeOffset returns the size of the partial file if it was written from
+// the same version of the remote file, or 0.
+func resumeOffset(part string, size int64, mtime time.Time) int64 {
+	info, err := os.Stat(part)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+
+	if !info.ModTime().Equal(mtime) || info.Size() > size {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// downloadPart writes the remote file from offset into the partial file
+func (c *ServerConn) downloadPart(remote, part string, offset int64, progress *progressTracker) (written int64, err error) {
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset > 0 {
+		flag |= os.O_APPEND
+	} else {
+		flag |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(part, flag, 0o644)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := c.RetrFrom(remote, uint64(offset))
+	if err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+
+	written, err = io.Copy(&progressWriter{w: f, progress: progress}, r)
+	if errClose := r.Close(); err == nil {
+		err = errClose
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+
+	return written, err
+}
+
+// UploadFile uploads the local file to the remote path.
+//
+// The data is written to the remote path suffixed with ".part" which is
+// renamed to the remote path once the upload is complete, so that the
+// consumers of the server never see a partial file. A later call resumes an
+// interrupted upload, unless the local file has changed in between: the
+// modification time of the partial file is set to the one of the local file
+// after a failure, and when it could not be, for example because the
+// connection was lost, the checksum of the partial file is compared with the
+// beginning of the local file. The upload is resumed with REST and STOR when
+// the server supports it, with APPE otherwise. It restarts from the beginning
+// when the server can neither set the modification time of a file nor
+// compute its checksum.
+//
+// The size of the uploaded file is verified, as well as its checksum when the
+// server supports one of the commands used by Hash. A partial file which fails
+// the verification is deleted.
+func (c *ServerConn) UploadFile(local, remote string, options ...TransferOption) error {
+	to := newTransferOptions(options)
+	defer c.overrideRateLimit(to.rateLimiter)()
+
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	part := to.part(remote)
+	var offset int64
+	if !to.noResume && !to.noPartFile {
+		if offset, err = c.uploadOffset(f, part, info); err != nil {
+			return err
+		}
+	}
+
+	progress := newProgressTracker(remote, DirectionUpload, offset, info.Size(), to.progress)
+
+	err = c.uploadPart(f, part, offset, progress)
+	progress.finish()
+	if err != nil {
+		if part != remote && c.IsSetTimeSupported() {
+			// Allows to resume the upload later
+			_ = c.SetTime(part, info.ModTime())
+		}
+		return err
+	}
+
+	if err := c.verifyUpload(f, part, info.Size()); err != nil {
+		if part != remote && (errors.Is(err, ErrSizeMismatch) || errors.Is(err, ErrChecksumMismatch)) {
+			_ = c.Delete(part)
+		}
+		return err
+	}
+
+	if part != remote {
+		if err := c.Rename(part, remote); err != nil {
+			return err
+		}
+	}
+
+	if to.preserveMtime {
+		return c.SetTime(remote, info.ModTime())
+	}
+
+	return nil
+}
+
+// uploadOffset returns the size of the remote partial file if it was written
+// from the same version of the local file, or 0. The partial file is trusted
+// when its modification time was set to the one of the local file after a
+// failed upload. Otherwise, as when the connection was lost before it could be
+// set, the partial file is trusted when its checksum matches the beginning of
+// the local file.
+func (c *ServerConn) uploadOffset(f *os.File, part string, info fs.FileInfo) (int64, error) {
+	size, err := c.FileSize(part)
+	if err != nil {
+		if isProtocolError(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if size == 0 || size > info.Size() {
+		return 0, nil
+	}
+
+	if c.IsGetTimeSupported() && c.IsSetTimeSupported() {
+		mtime, err := c.GetTime(part)
+		if err != nil && !isProtocolError(err) {
+			return 0, err
+		}
+		// MDTM has a precision of one second
+		if err == nil && mtime.Equal(info.ModTime().Truncate(time.Second)) {
+			return size, nil
+		}
+	}
+
+	algo := c.preferredHash()
+	if algo == "" {
+		return 0, nil
+	}
+
+	err = c.VerifyHash(part, algo, io.NewSectionReader(f, 0, size))
+	switch {
+	case err == nil:
+		return size, nil
+	case isProtocolError(err) || errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrNotSupported):
+		return 0, nil
+	}
+	return 0, err
+}
+
+// uploadPart writes the local file from offset into the remote partial file
+func (c *ServerConn) uploadPart(f *os.File, part string, offset int64, progress *progressTracker) error {
+	r := withProgress(f, progress)
+	if offset == 0 {
+		return c.Stor(part, r)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if c.features["REST"] == "STREAM" {
+		return c.StorFrom(part, r, uint64(offset))
+	}
+	return c.Append(part, r)
+}
+
+// verifyUpload checks the size and the checksum of the uploaded file
+func (c *ServerConn) verifyUpload(f *os.File, part string, size int64) error {
+	remoteSize, err := c.FileSize(part)
+	if err != nil && !isProtocolError(err) {
+		return err
+	}
+	if err == nil && remoteSize != size {
+		return fmt.Errorf("%w: uploaded %d bytes to %s, expected %d", ErrSizeMismatch, remoteSize, part, size)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return c.verifyHash(part, f)
+}
+
+// isProtocolError reports whether err is a negative reply of the server
+func isProtocolError(err error) bool {
+	var ftpErr *Error
+	return errors.As(err, &ftpErr)
+}
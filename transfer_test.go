@@ -0,0 +1,339 @@
+package ftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordRest records the offsets requested with REST
+func recordRest(server *testServer) *[]int64 {
+	var rest []int64
+	server.handle("REST", func(sess *testSession, arg string) {
+		sess.builtin("REST", arg)
+		rest = append(rest, sess.rest)
+	})
+	return &rest
+}
+
+func TestDownloadFile(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	c := dialTestServer(t, server)
+
+	local := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, c.DownloadFile("/file", local))
+
+	buf, err := os.ReadFile(local)
+	assert.NoError(err)
+	assert.Equal(testData, string(buf))
+
+	info, err := os.Stat(local)
+	if assert.NoError(err) {
+		assert.True(info.ModTime().Equal(testModTime))
+	}
+
+	_, err = os.Stat(local + DefaultPartSuffix)
+	assert.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	rest := recordRest(server)
+	c := dialTestServer(t, server)
+
+	local := filepath.Join(t.TempDir(), "file")
+	part := local + DefaultPartSuffix
+	require.NoError(t, os.WriteFile(part, []byte(testData[:10]), 0o644))
+	require.NoError(t, os.Chtimes(part, testModTime, testModTime))
+
+	var progress []int64
+	err := c.DownloadFile("/file", local, TransferWithProgress(func(p Progress) {
+		assert.Equal(int64(len(testData)), p.Total)
+		progress = append(progress, p.Transferred)
+	}))
+	require.NoError(t, err)
+
+	buf, err := os.ReadFile(local)
+	assert.NoError(err)
+	assert.Equal(testData, string(buf))
+	assert.Equal([]int64{10}, *rest)
+	assert.Equal([]int64{int64(len(testData))}, progress)
+}
+
+func TestDownloadFileChanged(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	rest := recordRest(server)
+	c := dialTestServer(t, server)
+
+	local := filepath.Join(t.TempDir(), "file")
+	part := local + DefaultPartSuffix
+
+	// The partial file was written from another version of the remote file
+	require.NoError(t, os.WriteFile(part, []byte("stale data"), 0o644))
+	older := testModTime.Add(-time.Hour)
+	require.NoError(t, os.Chtimes(part, older, older))
+
+	require.NoError(t, c.DownloadFile("/file", local))
+
+	buf, err := os.ReadFile(local)
+	assert.NoError(err)
+	assert.Equal(testData, string(buf))
+	assert.Empty(*rest)
+
+	// Resuming can be disabled
+	require.NoError(t, os.WriteFile(part, []byte(testData[:10]), 0o644))
+	require.NoError(t, os.Chtimes(part, testModTime, testModTime))
+	require.NoError(t, c.DownloadFile("/file", local, TransferWithResume(false)))
+	assert.Empty(*rest)
+}
+
+func TestDownloadFileSizeMismatch(t *testing.T) {
+	server := newTestServer(t)
+	server.addFile("/file", []byte(testData))
+	server.handle("RETR", func(sess *testSession, arg string) {
+		sess.send([]byte(testData[:10]))
+	})
+	c := dialTestServer(t, server)
+
+	local := filepath.Join(t.TempDir(), "file")
+	err := c.DownloadFile("/file", local)
+	assert.ErrorIs(t, err, ErrSizeMismatch)
+
+	_, err = os.Stat(local)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(local + DefaultPartSuffix)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDownloadFileInterrupted(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file", bigTestData)
+	stall := stallUntilCleanup(t)
+	server.handle("RETR", func(sess *testSession, arg string) {
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+		sess.reply("150 Opening data connection")
+		_, _ = conn.Write(bigTestData[:1000])
+		<-stall
+	})
+	c := dialTestServer(t, server, DialWithShutTimeout(50*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	local := filepath.Join(t.TempDir(), "file")
+	err := c.DownloadFileContext(ctx, "/file", local)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	// The partial file is kept to resume the download later
+	info, err := os.Stat(local + DefaultPartSuffix)
+	if assert.NoError(err) {
+		assert.Equal(int64(1000), info.Size())
+		assert.True(info.ModTime().Equal(testModTime))
+	}
+}
+
+// recordCommands records the given commands received by the server
+func recordCommands(server *testServer, commands ...string) *[]string {
+	var mu sync.Mutex
+	var received []string
+	for _, command := range commands {
+		command := command
+		server.handle(command, func(sess *testSession, arg string) {
+			mu.Lock()
+			received = append(received, command)
+			mu.Unlock()
+			sess.builtin(command, arg)
+		})
+	}
+	return &received
+}
+
+func writeLocalFile(t *testing.T, data string) string {
+	t.Helper()
+
+	local := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(local, []byte(data), 0o644))
+	return local
+}
+
+func TestUploadFile(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	commands := recordCommands(server, "STOR", "RNTO", "HASH")
+	c := dialTestServer(t, server)
+
+	local := writeLocalFile(t, testData)
+	require.NoError(t, c.UploadFile(local, "/file"))
+
+	assert.Equal(testData, string(server.file("/file")))
+	assert.Nil(server.lookup("/file" + DefaultPartSuffix))
+	assert.Equal([]string{"STOR", "HASH", "RNTO"}, *commands)
+}
+
+func TestUploadFileResume(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file.part", []byte(testData[:10]))
+	rest := recordRest(server)
+	c := dialTestServer(t, server)
+
+	local := writeLocalFile(t, testData)
+	require.NoError(t, os.Chtimes(local, testModTime, testModTime))
+	require.NoError(t, c.UploadFile(local, "/file"))
+
+	assert.Equal(testData, string(server.file("/file")))
+	assert.Equal([]int64{10}, *rest)
+}
+
+func TestUploadFileStalePart(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file.part", []byte("stale data"))
+	rest := recordRest(server)
+	c := dialTestServer(t, server)
+
+	// The partial file was not written from this version of the local file
+	local := writeLocalFile(t, testData)
+	require.NoError(t, c.UploadFile(local, "/file"))
+
+	assert.Equal(testData, string(server.file("/file")))
+	assert.Empty(*rest)
+}
+
+func TestUploadFileResumeAfterFailure(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.handle("STOR", failOnce("STOR", func(sess *testSession, arg string) {
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+
+		// Keep only the beginning of the data
+		sess.reply("150 Opening data connection")
+		_, _ = io.ReadAll(conn)
+		_ = conn.Close()
+		server.addFile(sess.resolve(arg), []byte(testData[:10]))
+		sess.reply("451 Local error")
+	}))
+	rest := recordRest(server)
+	c := dialTestServer(t, server)
+
+	local := writeLocalFile(t, testData)
+	assert.Error(c.UploadFile(local, "/file"))
+	require.NoError(t, c.UploadFile(local, "/file"))
+
+	assert.Equal(testData, string(server.file("/file")))
+	assert.Equal([]int64{10}, *rest)
+}
+
+func TestUploadFileResumeAfterConnectionLoss(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.handle("STOR", failOnce("STOR", func(sess *testSession, arg string) {
+		conn, err := sess.openData()
+		if err != nil {
+			sess.reply("425 %s", err)
+			return
+		}
+
+		// Keep only the beginning of the data, and drop the connection
+		sess.reply("150 Opening data connection")
+		buf := make([]byte, 10)
+		_, _ = io.ReadFull(conn, buf)
+		server.addFile(sess.resolve(arg), buf)
+		_ = conn.Close()
+		_ = sess.conn.Close()
+	}))
+	rest := recordRest(server)
+
+	local := writeLocalFile(t, testData)
+	assert.Error(dialTestServer(t, server).UploadFile(local, "/file"))
+
+	// The modification time of the partial file could not be set, its
+	// checksum is compared instead
+	c := dialTestServer(t, server)
+	require.NoError(t, c.UploadFile(local, "/file"))
+
+	assert.Equal(testData, string(server.file("/file")))
+	assert.Equal([]int64{10}, *rest)
+}
+
+func TestUploadFileResumeAppend(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.addFile("/file.part", []byte(testData[:10]))
+	server.handle("FEAT", func(sess *testSession, arg string) {
+		sess.reply("211-Features:\r\n SIZE\r\n MDTM\r\n MFMT\r\n EPSV\r\n211 End")
+	})
+	commands := recordCommands(server, "STOR", "APPE")
+	c := dialTestServer(t, server)
+
+	local := writeLocalFile(t, testData)
+	require.NoError(t, os.Chtimes(local, testModTime, testModTime))
+	require.NoError(t, c.UploadFile(local, "/file"))
+
+	assert.Equal(testData, string(server.file("/file")))
+	assert.Equal([]string{"APPE"}, *commands)
+}
+
+func TestUploadFileChecksumMismatch(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	server.handle("STOR", func(sess *testSession, arg string) {
+		sess.stor(arg, false)
+
+		// Corrupt the data without changing its size
+		server.mu.Lock()
+		server.files[sess.resolve(arg)].data[0] ^= 0xff
+		server.mu.Unlock()
+	})
+	c := dialTestServer(t, server)
+
+	local := writeLocalFile(t, testData)
+	err := c.UploadFile(local, "/file")
+	assert.ErrorIs(err, ErrChecksumMismatch)
+
+	assert.Nil(server.lookup("/file"))
+	assert.Nil(server.lookup("/file" + DefaultPartSuffix))
+}
+
+func TestUploadFileOptions(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	commands := recordCommands(server, "STOR", "RNTO")
+	c := dialTestServer(t, server)
+
+	local := writeLocalFile(t, testData)
+	mtime := testModTime.Add(-time.Hour)
+	require.NoError(t, os.Chtimes(local, mtime, mtime))
+
+	err := c.UploadFile(local, "/file", TransferWithPartFile(false), TransferWithPreservedModTime(true))
+	require.NoError(t, err)
+
+	assert.Equal(testData, string(server.file("/file")))
+	assert.Equal([]string{"STOR"}, *commands)
+
+	remoteTime, err := c.GetTime("/file")
+	assert.NoError(err)
+	assert.True(remoteTime.Equal(mtime))
+}
@@ -0,0 +1,272 @@
+package ftp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// Create creates or truncates the named file, like os.Create.
+// The returned writer uploads the data with StorWriter.
+func (fsys *FS) Create(name string) (io.WriteCloser, error) {
+	return fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+}
+
+// OpenFile opens the named file for writing, like os.OpenFile. The data is
+// appended with AppendWriter if flag contains os.O_APPEND, otherwise the file
+// is replaced with StorWriter. The permissions are ignored.
+//
+// Use Open to read a file. The other operations of the FS fail until the
+// writer is closed.
+func (fsys *FS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	full, err := fsys.fullPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if err := fsys.lock("open", name); err != nil {
+		return nil, err
+	}
+	defer fsys.mu.Unlock()
+
+	if flag&os.O_CREATE == 0 || flag&os.O_EXCL != 0 {
+		_, err := fsys.stat(full, name)
+		switch {
+		case err == nil && flag&os.O_EXCL != 0:
+			err = fs.ErrExist
+		case isNotExist(err) && flag&os.O_CREATE != 0:
+			err = nil
+		}
+		if err != nil {
+			return nil, pathError("open", name, err)
+		}
+	}
+
+	open := fsys.c.StorWriter
+	if flag&os.O_APPEND != 0 {
+		open = fsys.c.AppendWriter
+	}
+
+	w, err := open(full)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	fsys.busy = true
+	return &fileWriter{WriteCloser: w, fsys: fsys, name: name}, nil
+}
+
+// fileWriter uploads the data written to a file of a FS
+type fileWriter struct {
+	io.WriteCloser
+	fsys   *FS
+	name   string
+	closed bool
+}
+
+func (w *fileWriter) Write(buf []byte) (int, error) {
+	if w.closed {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: fs.ErrClosed}
+	}
+
+	n, err := w.WriteCloser.Write(buf)
+	if err != nil {
+		return n, pathError("write", w.name, err)
+	}
+	return n, nil
+}
+
+// Close completes the upload and returns its result
+func (w *fileWriter) Close() error {
+	if w.closed {
+		return &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrClosed}
+	}
+	w.closed = true
+
+	err := w.WriteCloser.Close()
+
+	w.fsys.mu.Lock()
+	w.fsys.busy = false
+	w.fsys.mu.Unlock()
+
+	if err != nil {
+		return pathError("close", w.name, err)
+	}
+	return nil
+}
+
+// Mkdir creates the named directory, like os.Mkdir.
+// The permissions are ignored.
+func (fsys *FS) Mkdir(name string, perm fs.FileMode) error {
+	full, err := fsys.fullPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.lock("mkdir", name); err != nil {
+		return err
+	}
+	defer fsys.mu.Unlock()
+
+	if err := fsys.mkdir(full); err != nil {
+		return pathError("mkdir", name, err)
+	}
+	return nil
+}
+
+// mkdir creates a directory, telling if it fails because the path exists
+func (fsys *FS) mkdir(full string) error {
+	existing, err := fsys.c.mkdir(full)
+	if existing != nil {
+		return fs.ErrExist
+	}
+	return err
+}
+
+// MkdirAll creates the named directory along with its missing parents, like
+// os.MkdirAll. The permissions are ignored.
+func (fsys *FS) MkdirAll(name string, perm fs.FileMode) error {
+	full, err := fsys.fullPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.lock("mkdir", name); err != nil {
+		return err
+	}
+	defer fsys.mu.Unlock()
+
+	return fsys.mkdirAll(full, name)
+}
+
+func (fsys *FS) mkdirAll(full, name string) error {
+	info, err := fsys.stat(full, name)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if !isNotExist(err) {
+		return pathError("mkdir", name, err)
+	}
+
+	if name != "." {
+		if err := fsys.mkdirAll(path.Dir(full), path.Dir(name)); err != nil {
+			return err
+		}
+	}
+
+	err = fsys.mkdir(full)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return pathError("mkdir", name, err)
+	}
+	return nil
+}
+
+// Remove removes the named file or empty directory, like os.Remove.
+func (fsys *FS) Remove(name string) error {
+	full, err := fsys.fullPath("remove", name)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.lock("remove", name); err != nil {
+		return err
+	}
+	defer fsys.mu.Unlock()
+
+	info, err := fsys.stat(full, name)
+	if err == nil {
+		if info.IsDir() {
+			err = fsys.c.RemoveDir(full)
+		} else {
+			err = fsys.c.Delete(full)
+		}
+	}
+
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	return nil
+}
+
+// RemoveAll removes the named file or directory with its contents, like
+// os.RemoveAll. It returns nil if the path does not exist.
+func (fsys *FS) RemoveAll(name string) error {
+	full, err := fsys.fullPath("removeall", name)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.lock("removeall", name); err != nil {
+		return err
+	}
+	defer fsys.mu.Unlock()
+
+	info, err := fsys.stat(full, name)
+	if isNotExist(err) {
+		return nil
+	}
+
+	if err == nil {
+		if info.IsDir() {
+			err = fsys.c.RemoveDirRecur(full)
+		} else {
+			err = fsys.c.Delete(full)
+		}
+	}
+
+	if err != nil {
+		return pathError("removeall", name, err)
+	}
+	return nil
+}
+
+// Rename renames the file or directory oldname to newname, like os.Rename.
+func (fsys *FS) Rename(oldname, newname string) error {
+	oldFull, err := fsys.fullPath("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newFull, err := fsys.fullPath("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.lock("rename", newname); err != nil {
+		return err
+	}
+	defer fsys.mu.Unlock()
+
+	if err := fsys.c.Rename(oldFull, newFull); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fsError(err)}
+	}
+	return nil
+}
+
+// Chtimes changes the modification time of the named file with SetTime, like
+// os.Chtimes. The access time is ignored.
+func (fsys *FS) Chtimes(name string, atime, mtime time.Time) error {
+	full, err := fsys.fullPath("chtimes", name)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.lock("chtimes", name); err != nil {
+		return err
+	}
+	defer fsys.mu.Unlock()
+
+	if err := fsys.c.SetTime(full, mtime); err != nil {
+		return pathError("chtimes", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package ftp
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFSFile(t *testing.T, w io.WriteCloser, err error, data string) {
+	t.Helper()
+
+	require.NoError(t, err)
+	_, err = io.WriteString(w, data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+}
+
+func TestFSCreate(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestFS(t)
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	w, err := fsys.Create("new")
+	writeFSFile(t, w, err, "hello")
+	assert.Equal("hello", string(server.file("/root/new")))
+
+	w, err = fsys.OpenFile("new", os.O_WRONLY|os.O_APPEND, 0)
+	writeFSFile(t, w, err, ", world")
+	assert.Equal("hello, world", string(server.file("/root/new")))
+
+	w, err = fsys.Create("new")
+	writeFSFile(t, w, err, "truncated")
+	assert.Equal("truncated", string(server.file("/root/new")))
+
+	_, err = fsys.OpenFile("new", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	assert.ErrorIs(err, fs.ErrExist)
+
+	_, err = fsys.OpenFile("missing", os.O_WRONLY, 0)
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	_, err = fsys.OpenFile("new", os.O_RDONLY, 0)
+	assert.ErrorIs(err, fs.ErrInvalid)
+
+}
+
+func TestFSCreateMissingDir(t *testing.T) {
+	server := newTestFS(t)
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	_, err := fsys.Create("nodir/new")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// The FS is usable again
+	_, err = fsys.Stat("hello.txt")
+	assert.NoError(t, err)
+}
+
+func TestFSBusy(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestFS(t)
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	w, err := fsys.Create("new")
+	require.NoError(t, err)
+
+	// The other operations fail instead of waiting for the writer
+	_, err = fsys.Stat("hello.txt")
+	assert.ErrorIs(err, errBusy)
+	_, err = fsys.Create("other")
+	assert.ErrorIs(err, errBusy)
+	var pathErr *fs.PathError
+	if assert.ErrorAs(fsys.Mkdir("dir", 0o755), &pathErr) {
+		assert.Equal("mkdir", pathErr.Op)
+		assert.Equal("dir", pathErr.Path)
+	}
+
+	writeFSFile(t, w, nil, "hello")
+	assert.Equal("hello", string(server.file("/root/new")))
+
+	_, err = fsys.Stat("hello.txt")
+	assert.NoError(err)
+}
+
+func TestFSDirs(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestFS(t)
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	assert.NoError(fsys.Mkdir("new", 0o755))
+	assert.ErrorIs(fsys.Mkdir("new", 0o755), fs.ErrExist)
+	assert.ErrorIs(fsys.Mkdir("a/b", 0o755), fs.ErrNotExist)
+
+	assert.NoError(fsys.MkdirAll("a/b/c", 0o755))
+	assert.NoError(fsys.MkdirAll("a/b", 0o755))
+	assert.ErrorIs(fsys.MkdirAll("hello.txt/d", 0o755), fs.ErrExist)
+
+	info, err := fsys.Stat("a/b/c")
+	if assert.NoError(err) {
+		assert.True(info.IsDir())
+	}
+
+	assert.Error(fsys.Remove("dir"))
+	assert.NoError(fsys.Remove("emptydir"))
+	assert.NoError(fsys.Remove("hello.txt"))
+	assert.ErrorIs(fsys.Remove("hello.txt"), fs.ErrNotExist)
+
+	assert.NoError(fsys.RemoveAll("dir"))
+	assert.NoError(fsys.RemoveAll("dir"))
+	assert.Nil(server.lookup("/root/dir/sub"))
+	assert.NotNil(server.lookup("/outside"))
+}
+
+func TestFSMkdirAllStatError(t *testing.T) {
+	server := newTestFS(t)
+	server.handle("MLST", func(sess *testSession, arg string) {
+		sess.reply("451 Local error in processing")
+	})
+	commands := recordCommands(server, "MKD")
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	// Only a missing directory is created
+	err := fsys.MkdirAll("a/b", 0o755)
+	assert.ErrorContains(t, err, "Local error")
+	assert.NotErrorIs(t, err, fs.ErrNotExist)
+	assert.Empty(t, *commands)
+}
+
+func TestFSRenameChtimes(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestFS(t)
+	fsys := NewFS(dialTestServer(t, server), "/root")
+
+	assert.NoError(fsys.Rename("hello.txt", "dir/renamed"))
+	assert.Equal("hello, world\n", string(server.file("/root/dir/renamed")))
+
+	err := fsys.Rename("hello.txt", "other")
+	var linkErr *os.LinkError
+	if assert.ErrorAs(err, &linkErr) {
+		assert.Equal("hello.txt", linkErr.Old)
+	}
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	mtime := testModTime.AddDate(1, 0, 0)
+	assert.NoError(fsys.Chtimes("dir/renamed", mtime, mtime))
+	info, err := fsys.Stat("dir/renamed")
+	if assert.NoError(err) {
+		assert.True(info.ModTime().Equal(mtime))
+	}
+}
+
+func TestFSErrorKind(t *testing.T) {
+	for _, tt := range []struct {
+		code int
+		msg  string
+		kind error
+	}{
+		{StatusFileUnavailable, "/file: No such file or directory", fs.ErrNotExist},
+		{StatusFileUnavailable, "Permission denied", fs.ErrPermission},
+		{StatusFileUnavailable, "Directory already exists", fs.ErrExist},
+		{StatusFileUnavailable, "File does not exist", fs.ErrNotExist},
+		{StatusFileActionIgnored, "File unavailable", fs.ErrNotExist},
+		{StatusBadFileName, "File name not allowed", fs.ErrPermission},
+		{StatusBadFileName, "Could not create file", fs.ErrPermission},
+		{StatusBadFileName, "/dir/file: No such directory", fs.ErrNotExist},
+		{StatusNotLoggedIn, "Not logged in", fs.ErrPermission},
+		{StatusBadArguments, "Syntax error", nil},
+	} {
+		err := &Error{Command: "DELE", Code: tt.code, Msg: tt.msg}
+		assert.Equal(t, tt.kind, fsErrorKind(err), tt.msg)
+	}
+
+	assert.Nil(t, fsErrorKind(io.EOF))
+}